@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jd/devctl/config"
+)
+
+func (c *CLI) runBackup(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: devctl backup list|diff|restore|prune")
+	}
+
+	switch args[0] {
+	case "list":
+		return c.listBackups(args[1:])
+	case "diff":
+		return c.diffBackup(args[1:])
+	case "restore":
+		return c.restoreBackup(args[1:])
+	case "prune":
+		return c.pruneBackups(args[1:])
+	default:
+		return fmt.Errorf("unknown backup command %q", args[0])
+	}
+}
+
+// backupItem is the listing view of a backup.
+type backupItem struct {
+	Name string    `json:"name" yaml:"name"`
+	Time time.Time `json:"time" yaml:"time"`
+	Envs []string  `json:"envs" yaml:"envs"`
+	Err  string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func (c *CLI) listBackups(args []string) error {
+	fs := newFlagSet(c, "backup list")
+	output := fs.String("o", "", "output format, see devctl get")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 0, "backup list"); err != nil {
+		return err
+	}
+
+	backups, err := config.ListBackups()
+	if err != nil {
+		return err
+	}
+	items := []backupItem{}
+	l := listing{Columns: []string{"NAME", "TIME", "ENVS"}, WideColumns: []string{"ERROR"}}
+	for _, backup := range backups {
+		item := backupItem{Name: backup.Name, Time: backup.Time, Envs: backup.EnvIDs}
+		if item.Envs == nil {
+			item.Envs = []string{}
+		}
+		if backup.Err != nil {
+			item.Err = backup.Err.Error()
+		}
+		items = append(items, item)
+		l.Names = append(l.Names, backup.Name)
+		l.Rows = append(l.Rows, []string{backup.Name, backup.Time.Format(config.TimeLayout), strings.Join(backup.EnvIDs, ","), item.Err})
+	}
+	l.Items = items
+	return l.print(c.stdout, *output)
+}
+
+// diffBackup prints what restoring the backup would change.
+func (c *CLI) diffBackup(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "backup diff"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "backup diff <backup>"); err != nil {
+		return err
+	}
+
+	backup, err := config.FindBackup(args[0])
+	if err != nil {
+		return err
+	}
+	restored, err := backup.Load()
+	if err != nil {
+		return err
+	}
+
+	diffs := config.DiffConfigs(c.envManager.Config, c.envManager.Config.Compose(restored))
+	if len(diffs) == 0 {
+		fmt.Fprintf(c.stdout, "%s has the same environments as the current config\n", backup.Name)
+		return nil
+	}
+	for _, diff := range diffs {
+		fmt.Fprint(c.stdout, diff)
+	}
+	return nil
+}
+
+func (c *CLI) restoreBackup(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "backup restore"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "backup restore <backup>"); err != nil {
+		return err
+	}
+
+	backup, err := config.FindBackup(args[0])
+	if err != nil {
+		return err
+	}
+	missing, err := c.envManager.RestoreBackup(backup)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Restored config from %s\n", backup.Name)
+	if len(missing) > 0 {
+		fmt.Fprintf(c.stderr, "Warning: could not download the kubeconfig of %s; update their credentials and re-add them\n", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (c *CLI) pruneBackups(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "backup prune"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 0, "backup prune"); err != nil {
+		return err
+	}
+	return c.envManager.Config.PruneBackups(c.log)
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/jd/devctl/cluster"
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/env"
+	"github.com/jd/devctl/logger"
+)
+
+// CLI runs devctl subcommands against the same environment registry as the
+// UI.
+type CLI struct {
+	envManager *env.EnvManager
+	log        *logger.Logger
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+}
+
+type command struct {
+	usage string
+	run   func(c *CLI, args []string) error
+}
+
+func NewCLI(cfg *config.Config, log *logger.Logger) *CLI {
+	return &CLI{
+		envManager: env.NewEnvManager(cfg, log),
+		log:        log,
+		stdin:      os.Stdin,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+	}
+}
+
+func (c *CLI) commands() map[string]command {
+	return map[string]command{
+		"env":              {usage: "env list|add|update|delete", run: (*CLI).runEnv},
+		"backup":           {usage: "backup list|diff <backup>|restore <backup>|prune", run: (*CLI).runBackup},
+		"cluster":          {usage: "cluster list <env>", run: (*CLI).runCluster},
+		"kubeconfig":       {usage: "kubeconfig <env> <cluster>", run: (*CLI).runKubeconfig},
+		"k9s":              {usage: "k9s <env> <cluster>", run: (*CLI).runK9s},
+		"exec":             {usage: "exec --env <env> [--cluster <cluster> | --all-clusters] [-j n] [--timeout d] -- <command>", run: (*CLI).runFanOut},
+		"node-exec":        {usage: "node-exec <env>/<cluster> [--node <node>] [-j n] [--timeout d] [--accept-host-key] -- <command>", run: (*CLI).runNodeExec},
+		"launch":           {usage: "launch <launcher> <env>/<cluster>", run: (*CLI).runLaunch},
+		"merge-kubeconfig": {usage: "merge-kubeconfig [--output path] [env...]", run: (*CLI).runMergeKubeconfig},
+		"get":              {usage: "get envs|clusters <env>|nodes <env>/<cluster> [-o format]", run: (*CLI).runGet},
+		"use":              {usage: "use <env>/<cluster> | use --unset", run: (*CLI).runUse},
+		"hook":             {usage: "hook bash|zsh|fish", run: (*CLI).runHook},
+		"validate":         {usage: "validate", run: (*CLI).runValidate},
+		"completion":       {usage: "completion bash|zsh|fish", run: (*CLI).runCompletion},
+	}
+}
+
+// Run executes the subcommand named by args[0].
+func (c *CLI) Run(args []string) error {
+	if len(args) > 0 && args[0] == CompleteCommand {
+		return c.runComplete(args[1:])
+	}
+
+	args, ignoreProblems := stripIgnoreProblems(args)
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.usage()
+		return nil
+	}
+
+	cmd, ok := c.commands()[args[0]]
+	if !ok {
+		c.usage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	c.log.Info("Running command: %s", args[0])
+	if err := c.checkProblems(args[0], ignoreProblems); err != nil {
+		return err
+	}
+	if err := cmd.run(c, args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		return err
+	}
+	return nil
+}
+
+func (c *CLI) usage() {
+	var usages []string
+	for _, cmd := range c.commands() {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+
+	fmt.Fprintln(c.stderr, "Usage: devctl [command]")
+	fmt.Fprintln(c.stderr, "\nWithout a command, devctl starts the interactive UI.")
+	fmt.Fprintln(c.stderr, "\nCommands:")
+	for _, usage := range usages {
+		fmt.Fprintf(c.stderr, "  devctl %s\n", usage)
+	}
+	fmt.Fprintf(c.stderr, "\nCommands other than env, backup and validate refuse to run while the config has problems, unless given %s.\n", IgnoreProblemsFlag)
+	fmt.Fprintf(c.stderr, "\nLink devctl as %s on your PATH to use it as `kubectl devctl`.\n", PluginName)
+}
+
+// parseFlags parses fs allowing flags after positional arguments, which the
+// flag package does not, and returns the positional arguments. Arguments
+// after "--" are passed through untouched.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, rest = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func newFlagSet(c *CLI, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("devctl "+name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// clusterManager returns the cluster manager of envID; callers must Close it.
+func (c *CLI) clusterManager(envID string) (*cluster.ClusterManager, error) {
+	env, err := c.envManager.GetEnvironment(envID)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.envManager.EnsureKubeconfig(env); err != nil {
+		return nil, err
+	}
+	return cluster.NewClusterManager(envID, c.envManager.Config, c.log), nil
+}
+
+func expectArgs(args []string, n int, usage string) error {
+	if len(args) != n {
+		return fmt.Errorf("usage: devctl %s", usage)
+	}
+	return nil
+}
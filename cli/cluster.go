@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"text/tabwriter"
+
+	"github.com/jd/devctl/cluster"
+)
+
+func (c *CLI) runCluster(args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf("usage: devctl cluster list <env>")
+	}
+
+	fs := newFlagSet(c, "cluster list")
+	output := fs.String("o", "", "output format, see devctl get")
+	args, err := parseFlags(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "cluster list <env>"); err != nil {
+		return err
+	}
+
+	clusters, err := c.listClusters(args[0])
+	if err != nil {
+		return err
+	}
+	return clusterListing(clusters).print(c.stdout, *output)
+}
+
+func (c *CLI) runKubeconfig(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "kubeconfig"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 2, "kubeconfig <env> <cluster>"); err != nil {
+		return err
+	}
+
+	cm, err := c.clusterManager(args[0])
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	kubeconfigPath, err := cm.GetKubeconfig(args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, kubeconfigPath)
+	return nil
+}
+
+func (c *CLI) runK9s(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "k9s"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 2, "k9s <env> <cluster>"); err != nil {
+		return err
+	}
+	return c.launch("k9s", args[0], args[1])
+}
+
+func (c *CLI) runLaunch(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "launch"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 2, "launch <launcher> <env>/<cluster>"); err != nil {
+		return err
+	}
+	envID, clusterID := cluster.ParseContextName(args[1])
+	return c.launch(args[0], envID, clusterID)
+}
+
+// launch runs the named launcher against a cluster, taking its API server
+// from the cached cluster listing.
+func (c *CLI) launch(name, envID, clusterID string) error {
+	launcher, ok := c.envManager.Config.GetLauncher(name)
+	if !ok {
+		return fmt.Errorf("launcher %s not found", name)
+	}
+
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	info := cluster.ClusterInfo{ID: clusterID}
+	if clusters, err := cluster.CachedClusters(envID); err == nil {
+		for _, cached := range clusters {
+			if cached.ID == clusterID {
+				info = cached
+			}
+		}
+	}
+
+	cmd, err := cm.LaunchCommand(launcher, info)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Catch Ctrl-C meant for the command so the tunnels stay up until it exits
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("%s command failed: %v", name, err)
+	}
+	return nil
+}
+
+func (c *CLI) runMergeKubeconfig(args []string) error {
+	fs := newFlagSet(c, "merge-kubeconfig")
+	output := fs.String("output", cluster.DefaultMergedKubeconfigPath(), "merged kubeconfig to create or update")
+	envIDs, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	for _, envID := range envIDs {
+		if _, err := c.envManager.GetEnvironment(envID); err != nil {
+			return err
+		}
+	}
+
+	results, err := cluster.MergeKubeconfigs(c.envManager.Config, c.log, envIDs, *output)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tSTATUS")
+	var failed int
+	for _, result := range results {
+		name, status := cluster.ContextName(result.EnvID, result.ClusterID), "merged"
+		if result.ClusterID == "" {
+			name = result.EnvID + "/*"
+		}
+		switch {
+		case result.Skipped != "":
+			status = "skipped: " + result.Skipped
+		case result.Err != nil:
+			failed++
+			status = "unreachable: " + result.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, status)
+	}
+	w.Flush()
+
+	fmt.Fprintf(c.stdout, "\nWrote %s\n", *output)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d entries unreachable, their previous contexts were kept", failed, len(results))
+	}
+	return nil
+}
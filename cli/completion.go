@@ -0,0 +1,212 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jd/devctl/cluster"
+	"github.com/jd/devctl/config"
+)
+
+// CompleteCommand is the hidden command the completion scripts call with the
+// words typed so far, the last one being completed.
+const CompleteCommand = "__complete"
+
+func (c *CLI) runCompletion(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "completion"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "completion bash|zsh|fish"); err != nil {
+		return err
+	}
+
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", args[0])
+	}
+	fmt.Fprint(c.stdout, script)
+	return nil
+}
+
+// runComplete prints the candidates for the last of args, one per line.
+// Cluster IDs come from the cache ListClusters maintains, never from the API
+// server.
+func (c *CLI) runComplete(args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	words, current := args[:len(args)-1], args[len(args)-1]
+
+	for _, candidate := range c.candidates(words, current) {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Fprintln(c.stdout, candidate)
+		}
+	}
+	return nil
+}
+
+func (c *CLI) candidates(words []string, current string) []string {
+	var positional []string
+	for i, word := range words {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		if i > 0 && words[i-1] == "-o" {
+			continue
+		}
+		positional = append(positional, word)
+	}
+	if len(words) > 0 && words[len(words)-1] == "-o" {
+		return append(append([]string{}, outputFormats...), "jsonpath=", "go-template=")
+	}
+
+	if len(positional) == 0 {
+		var commands []string
+		for name := range c.commands() {
+			commands = append(commands, name)
+		}
+		return commands
+	}
+
+	// Position of the word being completed among the command's arguments
+	n := len(positional) - 1
+	switch positional[0] {
+	case "env":
+		switch {
+		case n == 0:
+			return []string{"list", "add", "update", "delete"}
+		case n == 1 && (positional[1] == "update" || positional[1] == "delete"):
+			return c.envIDs()
+		}
+	case "backup":
+		switch {
+		case n == 0:
+			return []string{"list", "diff", "restore", "prune"}
+		case n == 1 && (positional[1] == "diff" || positional[1] == "restore"):
+			return backupNames()
+		}
+	case "cluster":
+		switch n {
+		case 0:
+			return []string{"list"}
+		case 1:
+			return c.envIDs()
+		}
+	case "kubeconfig", "k9s":
+		switch n {
+		case 0:
+			return c.envIDs()
+		case 1:
+			return c.clusterIDs(positional[1])
+		}
+	case "use", "node-exec":
+		if n == 0 {
+			return c.contextNames(current)
+		}
+	case "launch":
+		switch n {
+		case 0:
+			var names []string
+			for _, launcher := range c.envManager.Config.GetLaunchers() {
+				names = append(names, launcher.Name)
+			}
+			return names
+		case 1:
+			return c.contextNames(current)
+		}
+	case "get":
+		switch {
+		case n == 0:
+			return []string{"envs", "clusters", "nodes"}
+		case n == 1 && positional[1] == "clusters":
+			return c.envIDs()
+		case n == 1 && positional[1] == "nodes":
+			return c.contextNames(current)
+		}
+	case "hook", "completion":
+		if n == 0 {
+			return []string{"bash", "zsh", "fish"}
+		}
+	}
+	return nil
+}
+
+func backupNames() []string {
+	backups, err := config.ListBackupFiles()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, backup := range backups {
+		names = append(names, backup.Name)
+	}
+	return names
+}
+
+func (c *CLI) envIDs() []string {
+	var ids []string
+	for _, env := range c.envManager.ListEnvironments() {
+		ids = append(ids, env.ID)
+	}
+	return ids
+}
+
+func (c *CLI) clusterIDs(envID string) []string {
+	ids := []string{"gaia"}
+	clusters, err := cluster.CachedClusters(envID)
+	if err != nil {
+		return ids
+	}
+	for _, info := range clusters {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+// contextNames completes <env>/<cluster>, listing the clusters of an
+// environment only once its ID has been typed.
+func (c *CLI) contextNames(current string) []string {
+	envID, _, ok := strings.Cut(current, "/")
+	if !ok {
+		var names []string
+		for _, id := range c.envIDs() {
+			names = append(names, id+"/")
+		}
+		return names
+	}
+
+	var names []string
+	for _, clusterID := range c.clusterIDs(envID) {
+		names = append(names, cluster.ContextName(envID, clusterID))
+	}
+	return names
+}
+
+var completionScripts = map[string]string{
+	"bash": `_devctl() {
+  local IFS=$'\n'
+  COMPREPLY=($(devctl __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+  if [ "${#COMPREPLY[@]}" = 1 ] && [ "${COMPREPLY[0]%/}" != "${COMPREPLY[0]}" ]; then
+    compopt -o nospace
+  fi
+}
+complete -o default -F _devctl devctl
+`,
+	"zsh": `#compdef devctl
+_devctl() {
+  local -a candidates
+  candidates=("${(@f)$(devctl __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  compadd -S '' -- ${(M)candidates:#*/}
+  compadd -- ${candidates:#*/}
+}
+compdef _devctl devctl
+`,
+	"fish": `function __devctl_complete
+  set -l words (commandline -opc)
+  set -e words[1]
+  devctl __complete $words (commandline -ct) 2>/dev/null
+end
+complete -c devctl -f -a '(__devctl_complete)'
+`,
+}
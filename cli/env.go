@@ -0,0 +1,204 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/credential"
+	"github.com/jd/devctl/ssh"
+)
+
+func (c *CLI) runEnv(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: devctl env list|add|update|delete")
+	}
+
+	switch args[0] {
+	case "list":
+		return c.listEnvs(args[1:])
+	case "add":
+		return c.addEnv(args[1:])
+	case "update":
+		return c.updateEnv(args[1:])
+	case "delete":
+		return c.deleteEnv(args[1:])
+	default:
+		return fmt.Errorf("unknown env command %q", args[0])
+	}
+}
+
+func (c *CLI) listEnvs(args []string) error {
+	fs := newFlagSet(c, "env list")
+	output := fs.String("o", "", "output format, see devctl get")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 0, "env list"); err != nil {
+		return err
+	}
+	return envListing(c.envManager.ListEnvironments()).print(c.stdout, *output)
+}
+
+// envFlags are the environment fields settable from the command line. Only
+// flags given explicitly are applied, so update leaves the others alone.
+type envFlags struct {
+	fs            *flag.FlagSet
+	name          string
+	ip            string
+	port          int
+	user          string
+	password      string
+	passwordStdin bool
+	keyFiles      string
+	keyPassphrase string
+	authMethods   string
+	tunnel        bool
+	proxy         string
+}
+
+func newEnvFlags(c *CLI, name string) *envFlags {
+	f := &envFlags{fs: newFlagSet(c, name)}
+	f.fs.StringVar(&f.name, "name", "", "display name")
+	f.fs.StringVar(&f.ip, "ip", "", "jump host address")
+	f.fs.IntVar(&f.port, "port", 22, "jump host SSH port")
+	f.fs.StringVar(&f.user, "user", "", "jump host user")
+	f.fs.StringVar(&f.password, "password", "", "jump host password")
+	f.fs.BoolVar(&f.passwordStdin, "password-stdin", false, "read the password from stdin")
+	f.fs.StringVar(&f.keyFiles, "key-files", "", "comma-separated private key files")
+	f.fs.StringVar(&f.keyPassphrase, "key-passphrase", "", "private key passphrase")
+	f.fs.StringVar(&f.authMethods, "auth", "", "comma-separated auth method order")
+	f.fs.BoolVar(&f.tunnel, "tunnel-api-server", false, "reach API servers through the jump host")
+	f.fs.StringVar(&f.proxy, "proxy", "", "socks5:// or http:// proxy for API servers")
+	return f
+}
+
+func (f *envFlags) apply(env *config.Environment, stdin io.Reader) error {
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "name":
+			env.Name = f.name
+		case "ip":
+			env.IP = f.ip
+		case "port":
+			env.Port = f.port
+		case "user":
+			env.User = f.user
+		case "password":
+			env.Password = credential.Escape(f.password)
+		case "password-stdin":
+			if f.passwordStdin {
+				var password string
+				password, err = readSecret(stdin)
+				env.Password = credential.Escape(password)
+			}
+		case "key-files":
+			env.KeyFiles = splitList(f.keyFiles)
+		case "key-passphrase":
+			env.KeyPassphrase = credential.Escape(f.keyPassphrase)
+		case "auth":
+			env.AuthMethods = splitList(f.authMethods)
+		case "tunnel-api-server":
+			env.TunnelAPIServer = f.tunnel
+		case "proxy":
+			env.Proxy = f.proxy
+		}
+	})
+	return err
+}
+
+func (c *CLI) addEnv(args []string) error {
+	flags := newEnvFlags(c, "env add")
+	acceptHostKey := flags.fs.Bool("accept-host-key", false, "trust the jump host key if it is not known yet")
+	args, err := parseFlags(flags.fs, args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "env add <id> --ip <ip> --user <user> [flags]"); err != nil {
+		return err
+	}
+
+	env := config.Environment{ID: args[0], Name: args[0]}
+	if err := flags.apply(&env, c.stdin); err != nil {
+		return err
+	}
+	if env.IP == "" || env.User == "" {
+		return fmt.Errorf("--ip and --user are required")
+	}
+
+	err = c.envManager.AddEnvironment(env)
+	var keyErr *ssh.UnknownHostKeyError
+	if errors.As(err, &keyErr) && *acceptHostKey {
+		fmt.Fprintf(c.stderr, "Trusting host key %s %s for %s\n", keyErr.Key.Type(), keyErr.Fingerprint(), keyErr.Host)
+		if err := ssh.TrustHostKey(keyErr.Host, keyErr.Key); err != nil {
+			return err
+		}
+		err = c.envManager.AddEnvironment(env)
+	}
+	if errors.As(err, &keyErr) {
+		return fmt.Errorf("%v; verify the fingerprint and rerun with --accept-host-key to trust it", err)
+	}
+	return err
+}
+
+func (c *CLI) updateEnv(args []string) error {
+	flags := newEnvFlags(c, "env update")
+	args, err := parseFlags(flags.fs, args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "env update <id> [flags]"); err != nil {
+		return err
+	}
+	if args[0] == "default" {
+		return fmt.Errorf("the default environment cannot be updated")
+	}
+
+	env, err := c.envManager.GetEnvironment(args[0])
+	if err != nil {
+		return err
+	}
+	if err := flags.apply(&env, c.stdin); err != nil {
+		return err
+	}
+	return c.envManager.UpdateEnvironment(env)
+}
+
+func (c *CLI) deleteEnv(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "env delete"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "env delete <id>"); err != nil {
+		return err
+	}
+	if args[0] == "default" {
+		return fmt.Errorf("the default environment cannot be deleted")
+	}
+	return c.envManager.DeleteEnvironment(args[0])
+}
+
+// readSecret reads the first line of r, as for docker login --password-stdin.
+func readSecret(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read password from stdin: %v", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
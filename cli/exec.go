@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jd/devctl/cluster"
+)
+
+// stringList is a flag that may be repeated or given comma-separated values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, splitList(value)...)
+	return nil
+}
+
+func (c *CLI) runFanOut(args []string) error {
+	fs := newFlagSet(c, "exec")
+	var envIDs, clusterIDs stringList
+	fs.Var(&envIDs, "env", "environment to run in, repeatable")
+	allEnvs := fs.Bool("all-envs", false, "run in every environment")
+	fs.Var(&clusterIDs, "cluster", "cluster ID or <env>/<cluster> to run against, repeatable")
+	allClusters := fs.Bool("all-clusters", false, "run against every business cluster of the environments")
+	workers := fs.Int("j", cluster.DefaultFanOutWorkers, "clusters to run against concurrently")
+	timeout := fs.Duration("timeout", 0, "per-cluster timeout, such as 30s (default none)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || (len(clusterIDs) == 0 && !*allClusters) {
+		return fmt.Errorf("usage: devctl exec --env <env> [--cluster <cluster> | --all-clusters] -- <command>")
+	}
+
+	if *allEnvs {
+		envIDs = c.envIDs()
+	}
+	targets, failed := c.fanOutTargets(envIDs, clusterIDs, *allClusters)
+	if len(targets) == 0 {
+		return fmt.Errorf("no clusters to run against")
+	}
+
+	// Commands run in process groups of their own, which Ctrl-C does not
+	// reach, so it cancels them instead
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var mu sync.Mutex
+	results := cluster.FanOut(ctx, c.envManager.Config, c.log, targets, fs.Args(), *workers, *timeout, func(result cluster.ExecResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(c.stdout, "=== %s (%s)\n", result.Target, resultStatus(result))
+		c.stdout.Write(result.Output)
+		if len(result.Output) > 0 && !bytes.HasSuffix(result.Output, []byte("\n")) {
+			fmt.Fprintln(c.stdout)
+		}
+	})
+
+	fmt.Fprintln(c.stdout)
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tEXIT\tDURATION\tSTATUS")
+	for _, result := range results {
+		if result.ExitCode != 0 {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", result.Target, result.ExitCode, result.Duration.Round(time.Millisecond), resultStatus(result))
+	}
+	w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d clusters failed", failed, len(results))
+	}
+	return nil
+}
+
+// fanOutTargets expands the environments and clusters into targets. The
+// returned count is the environments whose clusters could not be listed.
+func (c *CLI) fanOutTargets(envIDs, clusterIDs []string, allClusters bool) ([]cluster.Target, int) {
+	var targets []cluster.Target
+	seen := make(map[cluster.Target]bool)
+	add := func(target cluster.Target) {
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+
+	var failed int
+	for _, id := range clusterIDs {
+		if strings.Contains(id, "/") {
+			envID, clusterID := cluster.ParseContextName(id)
+			add(cluster.Target{EnvID: envID, ClusterID: clusterID})
+			continue
+		}
+		for _, envID := range envIDs {
+			add(cluster.Target{EnvID: envID, ClusterID: id})
+		}
+	}
+
+	if allClusters {
+		for _, envID := range envIDs {
+			cm, err := c.clusterManager(envID)
+			if err != nil {
+				fmt.Fprintf(c.stderr, "Skipping %s: %v\n", envID, err)
+				failed++
+				continue
+			}
+			ids, err := cm.ListClusterSecrets()
+			cm.Close()
+			if err != nil {
+				fmt.Fprintf(c.stderr, "Skipping %s: %v\n", envID, err)
+				failed++
+				continue
+			}
+			for _, id := range ids {
+				if id != "gaia" {
+					add(cluster.Target{EnvID: envID, ClusterID: id})
+				}
+			}
+		}
+	}
+	return targets, failed
+}
+
+func resultStatus(result cluster.ExecResult) string {
+	switch {
+	case result.Err != nil:
+		return "error: " + result.Err.Error()
+	case result.ExitCode != 0:
+		return fmt.Sprintf("failed, exit %d", result.ExitCode)
+	default:
+		return "ok"
+	}
+}
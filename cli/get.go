@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jd/devctl/cluster"
+	"github.com/jd/devctl/config"
+)
+
+// envItem is the printable view of an environment, without its secrets.
+type envItem struct {
+	ID              string    `json:"id" yaml:"id"`
+	Name            string    `json:"name" yaml:"name"`
+	IP              string    `json:"ip" yaml:"ip"`
+	Port            int       `json:"port,omitempty" yaml:"port,omitempty"`
+	User            string    `json:"user" yaml:"user"`
+	Kubeconfig      string    `json:"kubeconfig" yaml:"kubeconfig"`
+	JumpHosts       int       `json:"jumpHosts,omitempty" yaml:"jumpHosts,omitempty"`
+	TunnelAPIServer bool      `json:"tunnelAPIServer,omitempty" yaml:"tunnelAPIServer,omitempty"`
+	Proxy           string    `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	Layer           string    `json:"layer" yaml:"layer"`
+	CreateTime      time.Time `json:"createTime" yaml:"createTime"`
+	UpdateTime      time.Time `json:"updateTime" yaml:"updateTime"`
+}
+
+func (c *CLI) runGet(args []string) error {
+	fs := newFlagSet(c, "get")
+	output := fs.String("o", "", "output format: table, wide, json, yaml, name, jsonpath=<expr> or go-template=<template>")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: devctl get envs|clusters <env>|nodes <env>/<cluster> [-o format]")
+	}
+
+	var l listing
+	switch args[0] {
+	case "envs", "env":
+		if err := expectArgs(args, 1, "get envs"); err != nil {
+			return err
+		}
+		l = envListing(c.envManager.ListEnvironments())
+	case "clusters", "cluster":
+		if err := expectArgs(args, 2, "get clusters <env>"); err != nil {
+			return err
+		}
+		clusters, err := c.listClusters(args[1])
+		if err != nil {
+			return err
+		}
+		l = clusterListing(clusters)
+	case "nodes", "node":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("usage: devctl get nodes <env>/<cluster>")
+		}
+		envID, clusterID := cluster.ParseContextName(args[1])
+		if len(args) == 3 {
+			clusterID = args[2]
+		}
+		nodes, err := c.listNodes(envID, clusterID)
+		if err != nil {
+			return err
+		}
+		l = nodeListing(cluster.ContextName(envID, clusterID), nodes)
+	default:
+		return fmt.Errorf("unknown resource %q, expected envs, clusters or nodes", args[0])
+	}
+	return l.print(c.stdout, *output)
+}
+
+func envListing(envs []config.Environment) listing {
+	l := listing{
+		Columns:     []string{"ID", "NAME", "IP", "USER", "LAYER", "UPDATED"},
+		WideColumns: []string{"PORT", "JUMPS", "TUNNEL", "PROXY", "KUBECONFIG"},
+	}
+	items := []envItem{}
+	for _, env := range envs {
+		items = append(items, envItem{
+			ID:              env.ID,
+			Name:            env.Name,
+			IP:              env.IP,
+			Port:            env.Port,
+			User:            env.User,
+			Kubeconfig:      env.Kubeconfig,
+			JumpHosts:       len(env.JumpHosts),
+			TunnelAPIServer: env.TunnelAPIServer,
+			Proxy:           env.Proxy,
+			Layer:           env.Layer,
+			CreateTime:      env.CreateTime,
+			UpdateTime:      env.UpdateTime,
+		})
+		l.Names = append(l.Names, env.ID)
+		port := env.Port
+		if port == 0 {
+			port = 22
+		}
+		l.Rows = append(l.Rows, []string{env.ID, env.Name, env.IP, env.User, env.Layer, config.FormatTime(env.UpdateTime),
+			strconv.Itoa(port), strconv.Itoa(len(env.JumpHosts)), strconv.FormatBool(env.TunnelAPIServer), env.Proxy, env.Kubeconfig})
+	}
+	l.Items = items
+	return l
+}
+
+func (c *CLI) listClusters(envID string) ([]cluster.ClusterInfo, error) {
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return nil, err
+	}
+	defer cm.Close()
+
+	clusters, err := cm.ListClusters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+	return clusters, nil
+}
+
+func clusterListing(clusters []cluster.ClusterInfo) listing {
+	if clusters == nil {
+		clusters = []cluster.ClusterInfo{}
+	}
+	l := listing{
+		Items:       clusters,
+		Columns:     []string{"ID", "NAME", "REGION", "VERSION", "STATUS"},
+		WideColumns: []string{"OS", "ARCH", "CRI", "APISERVER", "KUBECONFIG"},
+	}
+	for _, info := range clusters {
+		l.Names = append(l.Names, info.ID)
+		l.Rows = append(l.Rows, []string{info.ID, info.Name, info.Region, info.Version, info.Status,
+			info.OS, info.ARCH, info.Cri, info.ApiServer, info.Kubeconfig})
+	}
+	return l
+}
+
+func (c *CLI) listNodes(envID, clusterID string) ([]cluster.NodeInfo, error) {
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return nil, err
+	}
+	defer cm.Close()
+
+	return cm.ListClusterNodes(clusterID)
+}
+
+// nodeListing lists nodes of the cluster whose kubeconfig context is context.
+func nodeListing(context string, nodes []cluster.NodeInfo) listing {
+	if nodes == nil {
+		nodes = []cluster.NodeInfo{}
+	}
+	l := listing{
+		Items:       nodes,
+		Columns:     []string{"NAME", "INTERNAL-IP"},
+		WideColumns: []string{"CLUSTER"},
+	}
+	for _, node := range nodes {
+		l.Names = append(l.Names, node.Name)
+		l.Rows = append(l.Rows, []string{node.Name, node.IP, context})
+	}
+	return l
+}
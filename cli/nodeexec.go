@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jd/devctl/cluster"
+	"github.com/jd/devctl/ssh"
+)
+
+func (c *CLI) runNodeExec(args []string) error {
+	fs := newFlagSet(c, "node-exec")
+	var nodeNames stringList
+	fs.Var(&nodeNames, "node", "node name or IP to run on, repeatable (default all nodes)")
+	workers := fs.Int("j", cluster.DefaultNodeExecWorkers, "nodes to run on concurrently")
+	timeout := fs.Duration("timeout", 0, "per-node timeout, such as 30s (default none)")
+	acceptHostKey := fs.Bool("accept-host-key", false, "trust unknown node host keys, printing their fingerprints")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: devctl node-exec <env>/<cluster> [--node <node>] -- <command>")
+	}
+
+	envID, clusterID := cluster.ParseContextName(args[0])
+	// Joined like ssh does, the remote shell splits the words again
+	command := strings.Join(args[1:], " ")
+
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	nodes, err := cm.ListClusterNodes(clusterID)
+	if err != nil {
+		return err
+	}
+	nodes, err = selectNodes(nodes, nodeNames)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	var accept func(cluster.NodeInfo, *ssh.UnknownHostKeyError) bool
+	if *acceptHostKey {
+		accept = func(node cluster.NodeInfo, keyErr *ssh.UnknownHostKeyError) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(c.stderr, "[%s] trusting %s key %s\n", node.Name, keyErr.Key.Type(), keyErr.Fingerprint())
+			return true
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results, err := cm.RunOnNodes(ctx, clusterID, nodes, command, *workers, *timeout, accept, func(node cluster.NodeInfo, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(c.stdout, "[%s] %s\n", node.Name, line)
+	}, func(result cluster.NodeResult) {
+		if result.Err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(c.stderr, "[%s] %v\n", result.Node.Name, result.Err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout)
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tIP\tEXIT\tDURATION\tSTATUS")
+	failed, unknownKeys := 0, 0
+	for _, result := range results {
+		if result.ExitCode != 0 {
+			failed++
+		}
+		var keyErr *ssh.UnknownHostKeyError
+		if errors.As(result.Err, &keyErr) {
+			unknownKeys++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", result.Node.Name, result.Node.IP, result.ExitCode,
+			result.Duration.Round(time.Millisecond), nodeResultStatus(result))
+	}
+	w.Flush()
+
+	if unknownKeys > 0 {
+		return fmt.Errorf("%d of %d nodes failed, %d with unknown host keys; verify the fingerprints and rerun with --accept-host-key to trust them",
+			failed, len(results), unknownKeys)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d nodes failed", failed, len(results))
+	}
+	return nil
+}
+
+// selectNodes returns the nodes matching names by name or IP, all of them
+// when names is empty.
+func selectNodes(nodes []cluster.NodeInfo, names []string) ([]cluster.NodeInfo, error) {
+	if len(names) == 0 {
+		return nodes, nil
+	}
+
+	var selected []cluster.NodeInfo
+	for _, name := range names {
+		found := false
+		for _, node := range nodes {
+			if node.Name == name || node.IP == name {
+				selected = append(selected, node)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("node %q not found", name)
+		}
+	}
+	return selected, nil
+}
+
+func nodeResultStatus(result cluster.NodeResult) string {
+	switch {
+	case result.Err != nil:
+		return "error: " + result.Err.Error()
+	case result.ExitCode != 0:
+		return fmt.Sprintf("failed, exit %d", result.ExitCode)
+	default:
+		return "ok"
+	}
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// outputFormats lists the values accepted by -o, besides jsonpath=<expr> and
+// go-template=<template>.
+var outputFormats = []string{"table", "wide", "json", "yaml", "name"}
+
+// listing is a list of items printable in every output format. Rows hold the
+// wide columns, of which the table format shows the first len(Columns).
+type listing struct {
+	Items       interface{}
+	Names       []string
+	Columns     []string
+	WideColumns []string
+	Rows        [][]string
+}
+
+// print writes l in the given format. Structured formats wrap the items in an
+// {"items": [...]} object, as kubectl does for lists.
+func (l listing) print(w io.Writer, format string) error {
+	switch {
+	case format == "" || format == "table":
+		return l.printTable(w, len(l.Columns))
+	case format == "wide":
+		return l.printTable(w, len(l.Columns)+len(l.WideColumns))
+	case format == "name":
+		for _, name := range l.Names {
+			fmt.Fprintln(w, name)
+		}
+		return nil
+	case format == "json":
+		data, err := json.MarshalIndent(map[string]interface{}{"items": l.Items}, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case format == "yaml":
+		data, err := yaml.Marshal(map[string]interface{}{"items": l.Items})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case strings.HasPrefix(format, "jsonpath="):
+		return l.printJSONPath(w, strings.TrimPrefix(format, "jsonpath="))
+	case strings.HasPrefix(format, "go-template="):
+		return l.printTemplate(w, strings.TrimPrefix(format, "go-template="))
+	default:
+		return fmt.Errorf("unsupported output format %q, expected one of %s, jsonpath=<expr> or go-template=<template>",
+			format, strings.Join(outputFormats, ", "))
+	}
+}
+
+func (l listing) printTable(w io.Writer, columns int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	headers := append(append([]string{}, l.Columns...), l.WideColumns...)
+	fmt.Fprintln(tw, strings.Join(headers[:columns], "\t"))
+	for _, row := range l.Rows {
+		fmt.Fprintln(tw, strings.Join(row[:columns], "\t"))
+	}
+	return tw.Flush()
+}
+
+func (l listing) printJSONPath(w io.Writer, expr string) error {
+	data, err := l.generic()
+	if err != nil {
+		return err
+	}
+
+	// Accept .items[*].id as well as {.items[*].id}, like kubectl
+	if !strings.Contains(expr, "{") {
+		expr = "{" + expr + "}"
+	}
+	jp := jsonpath.New("output").AllowMissingKeys(true)
+	if err := jp.Parse(expr); err != nil {
+		return fmt.Errorf("invalid jsonpath %q: %v", expr, err)
+	}
+	if err := jp.Execute(w, data); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+func (l listing) printTemplate(w io.Writer, text string) error {
+	data, err := l.generic()
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New("output").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl.Execute(w, data)
+}
+
+// generic round-trips the items through JSON so that jsonpath and templates
+// address fields by their JSON names.
+func (l listing) generic() (interface{}, error) {
+	data, err := json.Marshal(map[string]interface{}{"items": l.Items})
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
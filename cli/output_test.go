@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jd/devctl/cluster"
+	"github.com/jd/devctl/config"
+)
+
+var (
+	testEnvs = []config.Environment{
+		{ID: "dev", Name: "Dev", IP: "10.0.0.1", User: "root", Layer: "personal", Kubeconfig: "/tmp/dev",
+			UpdateTime: time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)},
+		{ID: "prod", Name: "Prod", IP: "10.1.0.1", Port: 2222, User: "ops", Layer: "team",
+			JumpHosts: []config.JumpHost{{Host: "10.1.0.2"}}, TunnelAPIServer: true},
+	}
+	testClusters = []cluster.ClusterInfo{
+		{ID: "c1", Name: "alpha", Region: "east", Version: "v1.28.2", Status: "running", OS: "linux", ARCH: "amd64", Cri: "containerd"},
+		{ID: "c2", Name: "beta", Region: "west", Version: "v1.27.5", Status: "stopped"},
+	}
+	testNodes = []cluster.NodeInfo{
+		{Name: "master-1", IP: "192.168.0.10"},
+		{Name: "worker-1", IP: "192.168.0.11"},
+	}
+)
+
+func printed(t *testing.T, l listing, format string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := l.print(&out, format); err != nil {
+		t.Fatalf("print -o %s: %v", format, err)
+	}
+	return out.String()
+}
+
+func TestPrintTable(t *testing.T) {
+	got := printed(t, nodeListing("dev/c1", testNodes), "")
+	want := "NAME       INTERNAL-IP\n" +
+		"master-1   192.168.0.10\n" +
+		"worker-1   192.168.0.11\n"
+	if got != want {
+		t.Errorf("table =\n%s\nwant\n%s", got, want)
+	}
+
+	got = printed(t, nodeListing("dev/c1", testNodes), "wide")
+	if lines := strings.Split(got, "\n"); !strings.HasSuffix(lines[0], "CLUSTER") || !strings.HasSuffix(lines[1], "dev/c1") {
+		t.Errorf("wide table =\n%s\nwant a CLUSTER column", got)
+	}
+}
+
+func TestPrintFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing listing
+		format  string
+		want    string
+	}{
+		{name: "env table", listing: envListing(testEnvs), format: "table",
+			want: "ID     NAME   IP         USER   LAYER      UPDATED\n" +
+				"dev    Dev    10.0.0.1   root   personal   2024-01-02 15:04:05\n" +
+				"prod   Prod   10.1.0.1   ops    team       \n"},
+		{name: "env wide", listing: envListing(testEnvs), format: "wide",
+			want: "ID     NAME   IP         USER   LAYER      UPDATED               PORT   JUMPS   TUNNEL   PROXY   KUBECONFIG\n" +
+				"dev    Dev    10.0.0.1   root   personal   2024-01-02 15:04:05   22     0       false            /tmp/dev\n" +
+				"prod   Prod   10.1.0.1   ops    team                             2222   1       true             \n"},
+		{name: "cluster names", listing: clusterListing(testClusters), format: "name", want: "c1\nc2\n"},
+		{name: "cluster jsonpath", listing: clusterListing(testClusters), format: "jsonpath={.items[*].name}", want: "alpha beta\n"},
+		{name: "jsonpath without braces", listing: clusterListing(testClusters), format: "jsonpath=.items[0].version", want: "v1.28.2\n"},
+		{name: "node go-template", listing: nodeListing("dev/c1", testNodes), format: "go-template={{range .items}}{{.name}}={{.ip}}\n{{end}}",
+			want: "master-1=192.168.0.10\nworker-1=192.168.0.11\n"},
+		{name: "node yaml", listing: nodeListing("dev/c1", testNodes), format: "yaml",
+			want: "items:\n- name: master-1\n  ip: 192.168.0.10\n- name: worker-1\n  ip: 192.168.0.11\n"},
+		{name: "empty yaml", listing: nodeListing("dev/c1", nil), format: "yaml", want: "items: []\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := printed(t, tt.listing, tt.format); got != tt.want {
+				t.Errorf("-o %s =\n%q\nwant\n%q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	var out struct {
+		Items []struct {
+			ID              string `json:"id"`
+			Port            int    `json:"port"`
+			JumpHosts       int    `json:"jumpHosts"`
+			TunnelAPIServer bool   `json:"tunnelAPIServer"`
+			Layer           string `json:"layer"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(printed(t, envListing(testEnvs), "json")), &out); err != nil {
+		t.Fatalf("-o json is not JSON: %v", err)
+	}
+	if len(out.Items) != 2 {
+		t.Fatalf("got %d items, want 2", len(out.Items))
+	}
+	prod := out.Items[1]
+	if prod.ID != "prod" || prod.Port != 2222 || prod.JumpHosts != 1 || !prod.TunnelAPIServer || prod.Layer != "team" {
+		t.Errorf("prod = %+v", prod)
+	}
+
+	// An empty listing is still an object with an items list
+	if got := printed(t, clusterListing(nil), "json"); got != "{\n  \"items\": []\n}\n" {
+		t.Errorf("empty -o json = %q", got)
+	}
+}
+
+func TestPrintErrors(t *testing.T) {
+	for format, want := range map[string]string{
+		"xml":                     `unsupported output format "xml"`,
+		"jsonpath={.items[":       "invalid jsonpath",
+		"go-template={{.items":    "invalid template",
+		"custom-columns=NAME:.id": "unsupported output format",
+	} {
+		err := envListing(testEnvs).print(&bytes.Buffer{}, format)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("-o %s: err = %v, want %q", format, err, want)
+		}
+	}
+}
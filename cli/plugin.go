@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jd/devctl/cluster"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// PluginName is the executable name kubectl looks up for `kubectl devctl`.
+const PluginName = "kubectl-devctl"
+
+// IsKubectlPlugin reports whether devctl was invoked as a kubectl plugin,
+// through a kubectl-devctl link or copy.
+func IsKubectlPlugin(arg0 string) bool {
+	name := strings.TrimSuffix(filepath.Base(arg0), ".exe")
+	return name == PluginName
+}
+
+// ExitError makes devctl exit with Code without printing an error, for
+// commands whose failure the wrapped program already reported.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// RunPlugin executes `kubectl devctl` subcommands.
+func (c *CLI) RunPlugin(args []string) error {
+	args, ignoreProblems := stripIgnoreProblems(args)
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		c.pluginUsage()
+		return nil
+	}
+
+	if err := c.checkProblems(args[0], ignoreProblems); err != nil {
+		return err
+	}
+
+	var err error
+	switch args[0] {
+	case "switch":
+		err = c.runSwitch(args[1:])
+	case "clusters":
+		err = c.runPluginClusters(args[1:])
+	case "exec":
+		err = c.runExec(args[1:])
+	default:
+		c.pluginUsage()
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func (c *CLI) pluginUsage() {
+	fmt.Fprintln(c.stderr, "Usage: kubectl devctl [command]")
+	fmt.Fprintln(c.stderr, "\nCommands:")
+	fmt.Fprintln(c.stderr, "  kubectl devctl switch <env>/<cluster>              add the cluster to your kubeconfig and make it current")
+	fmt.Fprintln(c.stderr, "  kubectl devctl clusters [env...]                   list clusters as <env>/<cluster> contexts")
+	fmt.Fprintln(c.stderr, "  kubectl devctl exec -e <env> -c <cluster> -- args  run kubectl against a cluster")
+}
+
+func (c *CLI) runSwitch(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "switch"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "switch <env>/<cluster>"); err != nil {
+		return err
+	}
+
+	envID, clusterID := cluster.ParseContextName(args[0])
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	path := clientcmd.NewDefaultPathOptions().GetDefaultFilename()
+	name, err := cm.SwitchContext(clusterID, path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Switched to context %q in %s\n", name, path)
+	return nil
+}
+
+func (c *CLI) runPluginClusters(args []string) error {
+	envIDs, err := parseFlags(newFlagSet(c, "clusters"), args)
+	if err != nil {
+		return err
+	}
+	if len(envIDs) == 0 {
+		envIDs = c.envIDs()
+	}
+
+	current := ""
+	if kubeconfig, err := clientcmd.NewDefaultPathOptions().GetStartingConfig(); err == nil {
+		current = kubeconfig.CurrentContext
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "CURRENT\tNAME\tDISPLAY NAME\tVERSION\tSTATUS")
+	for _, envID := range envIDs {
+		cm, err := c.clusterManager(envID)
+		if err != nil {
+			return err
+		}
+		clusters, err := cm.ListClusters()
+		cm.Close()
+		if err != nil {
+			fmt.Fprintf(c.stderr, "Failed to list clusters of %s: %v\n", envID, err)
+			continue
+		}
+
+		clusters = append([]cluster.ClusterInfo{{ID: "gaia", Name: "management"}}, clusters...)
+		for _, info := range clusters {
+			name := cluster.ContextName(envID, info.ID)
+			mark := ""
+			if name == current {
+				mark = "*"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", mark, name, info.Name, info.Version, info.Status)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *CLI) runExec(args []string) error {
+	fs := newFlagSet(c, "exec")
+	var envID, clusterID string
+	fs.StringVar(&envID, "e", "", "environment ID")
+	fs.StringVar(&envID, "env", "", "environment ID")
+	fs.StringVar(&clusterID, "c", "", "cluster ID, or <env>/<cluster>")
+	fs.StringVar(&clusterID, "cluster", "", "cluster ID, or <env>/<cluster>")
+	// Stop at the first kubectl argument so its flags are passed through
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if strings.Contains(clusterID, "/") {
+		envID, clusterID = cluster.ParseContextName(clusterID)
+	}
+	if envID == "" || clusterID == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: kubectl devctl exec -e <env> -c <cluster> -- <kubectl args>")
+	}
+
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	kubeconfigPath, err := cm.GetKubeconfig(clusterID)
+	if err != nil {
+		return err
+	}
+	kubeconfigPath, err = cm.LaunchKubeconfig(kubeconfigPath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("kubectl", append([]string{"--kubeconfig", kubeconfigPath}, fs.Args()...)...)
+	cmd.Env = cm.LaunchEnv()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+
+	// Let kubectl handle Ctrl-C, e.g. to stop logs -f, while the tunnels stay up
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return fmt.Errorf("failed to run kubectl: %v", err)
+	}
+	return nil
+}
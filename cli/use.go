@@ -0,0 +1,177 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jd/devctl/cluster"
+)
+
+func (c *CLI) runUse(args []string) error {
+	fs := newFlagSet(c, "use")
+	shell := fs.String("shell", "", "shell syntax to print: bash, zsh or fish (default from $SHELL)")
+	unset := fs.Bool("unset", false, "print commands that clear the active cluster")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	syntax, err := shellSyntax(*shell)
+	if err != nil {
+		return err
+	}
+	if *unset {
+		fmt.Fprint(c.stdout, unsetCommands(syntax, "KUBECONFIG", cluster.ContextEnv))
+		return nil
+	}
+	if err := expectArgs(args, 1, "use <env>/<cluster>"); err != nil {
+		return err
+	}
+
+	envID, clusterID := cluster.ParseContextName(args[0])
+	cm, err := c.clusterManager(envID)
+	if err != nil {
+		return err
+	}
+	defer cm.Close()
+
+	kubeconfigPath, err := cm.GetKubeconfig(clusterID)
+	if err != nil {
+		return err
+	}
+	env, err := c.envManager.GetEnvironment(envID)
+	if err != nil {
+		return err
+	}
+	if env.TunnelAPIServer {
+		fmt.Fprintf(c.stderr, "Warning: environment %s tunnels its API servers, which only works from the devctl UI subshell\n", envID)
+	} else if kubeconfigPath, err = cm.LaunchKubeconfig(kubeconfigPath); err != nil {
+		return err
+	}
+
+	fmt.Fprint(c.stdout, exportCommands(syntax, [][2]string{
+		{"KUBECONFIG", kubeconfigPath},
+		{cluster.ContextEnv, cluster.ContextName(envID, clusterID)},
+	}))
+	return nil
+}
+
+func (c *CLI) runHook(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "hook"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 1, "hook bash|zsh|fish"); err != nil {
+		return err
+	}
+
+	hook, ok := shellHooks[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", args[0])
+	}
+	fmt.Fprint(c.stdout, hook)
+	return nil
+}
+
+func shellSyntax(shell string) (string, error) {
+	if shell == "" {
+		shell = filepath.Base(os.Getenv("SHELL"))
+	}
+	switch shell {
+	case "bash", "zsh", "sh", "":
+		return "sh", nil
+	case "fish":
+		return "fish", nil
+	default:
+		return "", fmt.Errorf("unsupported shell %q, expected bash, zsh or fish", shell)
+	}
+}
+
+func exportCommands(syntax string, vars [][2]string) string {
+	var b strings.Builder
+	for _, v := range vars {
+		if syntax == "fish" {
+			fmt.Fprintf(&b, "set -gx %s %s;\n", v[0], fishQuote(v[1]))
+		} else {
+			fmt.Fprintf(&b, "export %s=%s;\n", v[0], shellQuote(v[1]))
+		}
+	}
+	return b.String()
+}
+
+func unsetCommands(syntax string, names ...string) string {
+	var b strings.Builder
+	for _, name := range names {
+		if syntax == "fish" {
+			fmt.Fprintf(&b, "set -e %s;\n", name)
+		} else {
+			fmt.Fprintf(&b, "unset %s;\n", name)
+		}
+	}
+	return b.String()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s for fish, where backslash escapes quotes and itself
+// inside single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// shellHooks wrap devctl so that `devctl use` changes the current shell, and
+// prefix the prompt with the active <env>/<cluster>.
+var shellHooks = map[string]string{
+	"bash": `devctl() {
+  if [ "$1" = use ]; then
+    eval "$(command devctl "$@" --shell bash)"
+  else
+    command devctl "$@"
+  fi
+}
+__devctl_ps1() {
+  [ -n "$DEVCTL_CONTEXT" ] && printf '[%s] ' "$DEVCTL_CONTEXT"
+}
+case "$PS1" in
+  *__devctl_ps1*) ;;
+  *) PS1='$(__devctl_ps1)'"$PS1" ;;
+esac
+`,
+	"zsh": `devctl() {
+  if [ "$1" = use ]; then
+    eval "$(command devctl "$@" --shell zsh)"
+  else
+    command devctl "$@"
+  fi
+}
+__devctl_ps1() {
+  [ -n "$DEVCTL_CONTEXT" ] && printf '[%s] ' "$DEVCTL_CONTEXT"
+}
+setopt PROMPT_SUBST
+case "$PROMPT" in
+  *__devctl_ps1*) ;;
+  *) PROMPT='$(__devctl_ps1)'"$PROMPT" ;;
+esac
+`,
+	"fish": `function devctl
+  if test "$argv[1]" = use
+    command devctl $argv --shell fish | source
+  else
+    command devctl $argv
+  end
+end
+if not functions -q __devctl_orig_prompt
+  functions -c fish_prompt __devctl_orig_prompt
+  function fish_prompt
+    if set -q DEVCTL_CONTEXT
+      printf '[%s] ' $DEVCTL_CONTEXT
+    end
+    __devctl_orig_prompt
+  end
+end
+`,
+}
@@ -0,0 +1,67 @@
+package cli
+
+import "fmt"
+
+// runValidate prints the problems of the config, failing when there are any.
+func (c *CLI) runValidate(args []string) error {
+	args, err := parseFlags(newFlagSet(c, "validate"), args)
+	if err != nil {
+		return err
+	}
+	if err := expectArgs(args, 0, "validate"); err != nil {
+		return err
+	}
+
+	problems := c.envManager.Config.Validate()
+	for _, problem := range problems {
+		fmt.Fprintln(c.stdout, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in the config", len(problems))
+	}
+	fmt.Fprintln(c.stdout, "Config is valid")
+	return nil
+}
+
+// IgnoreProblemsFlag makes commands run despite config problems. It is
+// accepted anywhere before a "--".
+const IgnoreProblemsFlag = "--ignore-problems"
+
+// repairCommands are the commands that can fix or inspect a config with
+// problems, so they only warn about them.
+var repairCommands = map[string]bool{"env": true, "backup": true, "completion": true, "hook": true}
+
+// checkProblems fails when the config has problems, unless ignore is set or
+// command is one of repairCommands, in which case it only warns.
+func (c *CLI) checkProblems(command string, ignore bool) error {
+	if command == "validate" {
+		return nil
+	}
+	problems := c.envManager.Config.Validate()
+	if len(problems) == 0 {
+		return nil
+	}
+	if ignore || repairCommands[command] {
+		fmt.Fprintf(c.stderr, "Warning: found %d problems in the config, run devctl validate for details\n", len(problems))
+		return nil
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintln(c.stderr, problem)
+	}
+	return fmt.Errorf("found %d problems in the config, fix them or rerun with %s", len(problems), IgnoreProblemsFlag)
+}
+
+// stripIgnoreProblems removes IgnoreProblemsFlag from args, telling whether
+// it was there.
+func stripIgnoreProblems(args []string) ([]string, bool) {
+	for i, arg := range args {
+		switch arg {
+		case "--":
+			return args, false
+		case IgnoreProblemsFlag:
+			return append(append([]string{}, args[:i]...), args[i+1:]...), true
+		}
+	}
+	return args, false
+}
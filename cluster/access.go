@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/ssh"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// restConfig loads kubeconfigPath, pointed at a tunnel through the jump host
+// when the environment tunnels its API servers, or else through its proxy.
+func (cm *ClusterManager) restConfig(kubeconfigPath string) (*rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build config: %v", err)
+	}
+
+	env, err := cm.getEnvironment()
+	if err != nil {
+		return config, nil
+	}
+	if !env.TunnelAPIServer {
+		proxyURL, err := proxyURL(env)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			config.Proxy = http.ProxyURL(proxyURL)
+		}
+		return config, nil
+	}
+
+	server, serverName, err := cm.tunnelServer(config.Host)
+	if err != nil {
+		return nil, err
+	}
+	config.Host = server
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = serverName
+	}
+	return config, nil
+}
+
+// LaunchKubeconfig returns the kubeconfig to hand to external tools such as
+// k9s. When the environment tunnels its API servers or uses a proxy, this is
+// a copy of kubeconfigPath whose servers point at the tunnels, which stay
+// open until Close, or carry the proxy-url.
+func (cm *ClusterManager) LaunchKubeconfig(kubeconfigPath string) (string, error) {
+	env, err := cm.getEnvironment()
+	if err != nil {
+		return "", err
+	}
+	if !env.TunnelAPIServer && env.Proxy == "" {
+		return kubeconfigPath, nil
+	}
+
+	if _, err := proxyURL(env); err != nil {
+		return "", err
+	}
+
+	kubeconfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	for name, cluster := range kubeconfig.Clusters {
+		if !env.TunnelAPIServer {
+			cluster.ProxyURL = env.Proxy
+			continue
+		}
+
+		server, serverName, err := cm.tunnelServer(cluster.Server)
+		if err != nil {
+			return "", fmt.Errorf("failed to tunnel cluster %s: %w", name, err)
+		}
+		cluster.Server = server
+		if cluster.TLSServerName == "" {
+			cluster.TLSServerName = serverName
+		}
+	}
+
+	launchPath := filepath.Join(os.Getenv("HOME"), ".devctl", "kubeconfigs", cm.EnvID, ".launch", filepath.Base(kubeconfigPath))
+	if err := os.MkdirAll(filepath.Dir(launchPath), 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := clientcmd.WriteToFile(*kubeconfig, launchPath); err != nil {
+		return "", fmt.Errorf("failed to write kubeconfig: %v", err)
+	}
+	return launchPath, nil
+}
+
+// LaunchEnv returns the environment for external tools, with the proxy
+// variables set when the environment uses a proxy.
+func (cm *ClusterManager) LaunchEnv() []string {
+	environ := os.Environ()
+	env, err := cm.getEnvironment()
+	if err != nil || env.Proxy == "" || env.TunnelAPIServer {
+		return environ
+	}
+	return append(environ, "HTTPS_PROXY="+env.Proxy, "HTTP_PROXY="+env.Proxy, "https_proxy="+env.Proxy, "http_proxy="+env.Proxy)
+}
+
+func proxyURL(env *config.Environment) (*url.URL, error) {
+	if env.Proxy == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(env.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %s: %v", env.Proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	return u, nil
+}
+
+// tunnelServer forwards a local port to the API server at server and returns
+// the local URL along with the host name its certificate is issued for.
+func (cm *ClusterManager) tunnelServer(server string) (string, string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid server URL %s: %v", server, err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	forwarder, err := cm.getForwarder()
+	if err != nil {
+		return "", "", err
+	}
+	tunnel, err := forwarder.Forward(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to tunnel to %s: %w", addr, err)
+	}
+	cm.log.Info("Tunneling API server %s through %s", addr, tunnel.LocalAddr())
+
+	serverName := u.Hostname()
+	u.Host = tunnel.LocalAddr()
+	return u.String(), serverName, nil
+}
+
+func (cm *ClusterManager) getForwarder() (*ssh.Forwarder, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.forwarder != nil {
+		return cm.forwarder, nil
+	}
+
+	env, err := cm.getEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	store, err := cm.Config.CredentialStore()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credential store: %v", err)
+	}
+	cm.forwarder = ssh.NewForwarder(ssh.NewSSHClientForEnv(*env, store))
+	return cm.forwarder, nil
+}
+
+// Close stops the API server tunnels of the environment.
+func (cm *ClusterManager) Close() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if cm.forwarder == nil {
+		return nil
+	}
+	err := cm.forwarder.Close()
+	cm.forwarder = nil
+	return err
+}
@@ -0,0 +1,40 @@
+package cluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func clusterCachePath(envID string) string {
+	return filepath.Join(os.Getenv("HOME"), ".devctl", "cache", envID, "clusters.json")
+}
+
+func (cm *ClusterManager) cacheClusters(clusters []ClusterInfo) error {
+	data, err := json.Marshal(clusters)
+	if err != nil {
+		return err
+	}
+
+	path := clusterCachePath(cm.EnvID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// CachedClusters returns the clusters of envID as of the last ListClusters
+// call, without contacting the API server.
+func CachedClusters(envID string) ([]ClusterInfo, error) {
+	data, err := ioutil.ReadFile(clusterCachePath(envID))
+	if err != nil {
+		return nil, err
+	}
+
+	var clusters []ClusterInfo
+	if err := json.Unmarshal(data, &clusters); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
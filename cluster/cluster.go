@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jd/devctl/config"
 	"github.com/jd/devctl/logger"
@@ -18,13 +19,15 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type ClusterManager struct {
 	EnvID  string
 	Config *config.Config
 	log    *logger.Logger
+
+	mu        sync.Mutex
+	forwarder *ssh.Forwarder
 }
 
 func NewClusterManager(envID string, cfg *config.Config, log *logger.Logger) *ClusterManager {
@@ -35,22 +38,41 @@ func NewClusterManager(envID string, cfg *config.Config, log *logger.Logger) *Cl
 	}
 }
 
+// ContextEnv is set by devctl use and the UI subshell to the active
+// <envID>/<clusterID>, for shell prompts.
+const ContextEnv = "DEVCTL_CONTEXT"
+
+// ContextName names a cluster of an environment uniquely across environments.
+func ContextName(envID, clusterID string) string {
+	return envID + "/" + clusterID
+}
+
+// ParseContextName splits an <envID>/<clusterID> name; a bare environment ID
+// refers to its management cluster.
+func ParseContextName(name string) (envID, clusterID string) {
+	envID, clusterID, ok := strings.Cut(name, "/")
+	if !ok || clusterID == "" {
+		return envID, "gaia"
+	}
+	return envID, clusterID
+}
+
 type ClusterInfo struct {
-	ID         string
-	Name       string
-	OS         string
-	ARCH       string
-	Region     string
-	Kubeconfig string
-	ApiServer  string
-	Cri        string
-	Version    string
-	Status     string
+	ID         string `json:"id" yaml:"id"`
+	Name       string `json:"name" yaml:"name"`
+	OS         string `json:"os" yaml:"os"`
+	ARCH       string `json:"arch" yaml:"arch"`
+	Region     string `json:"region" yaml:"region"`
+	Kubeconfig string `json:"kubeconfig" yaml:"kubeconfig"`
+	ApiServer  string `json:"apiServer" yaml:"apiServer"`
+	Cri        string `json:"cri" yaml:"cri"`
+	Version    string `json:"version" yaml:"version"`
+	Status     string `json:"status" yaml:"status"`
 }
 
 type NodeInfo struct {
-	Name string
-	IP   string
+	Name string `json:"name" yaml:"name"`
+	IP   string `json:"ip" yaml:"ip"`
 }
 
 func (cm *ClusterManager) ListClusterNodes(clusterName string) ([]NodeInfo, error) {
@@ -63,7 +85,7 @@ func (cm *ClusterManager) ListClusterNodes(clusterName string) ([]NodeInfo, erro
 
 	clientset, err := cm.getClientset(kubeconfigPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get clientset for cluster %s: %v", clusterName, err)
+		return nil, fmt.Errorf("failed to get clientset for cluster %s: %w", clusterName, err)
 	}
 
 	nodeList, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
@@ -153,6 +175,11 @@ func (cm *ClusterManager) ListClusters() ([]ClusterInfo, error) {
 			Status:     strconv.FormatBool(status),
 		})
 	}
+
+	// Keep a copy for shell completion, which must not hit the API server
+	if err := cm.cacheClusters(clusters); err != nil {
+		cm.log.Warning("Failed to cache cluster list: %v", err)
+	}
 	return clusters, nil
 }
 
@@ -215,7 +242,12 @@ func (cm *ClusterManager) GetKubeconfig(clusterName string) (string, error) {
 	if clusterName == "gaia" {
 		cm.log.Info("Downloading kubeconfig for management cluster (gaia)")
 		// Download the kubeconfig file from the management cluster
-		sshClient := ssh.NewSSHClient(env.IP, env.User, env.Password)
+		store, err := cm.Config.CredentialStore()
+		if err != nil {
+			cm.log.Error("Failed to open credential store: %v", err)
+			return "", fmt.Errorf("failed to open credential store: %v", err)
+		}
+		sshClient := ssh.NewSSHClientForEnv(*env, store)
 		remotePath := "/root/.kube/config"
 
 		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
@@ -225,7 +257,7 @@ func (cm *ClusterManager) GetKubeconfig(clusterName string) (string, error) {
 
 		if err := sshClient.DownloadFile(remotePath, localPath); err != nil {
 			cm.log.Error("Failed to download kubeconfig: %v", err)
-			return "", fmt.Errorf("failed to download kubeconfig: %v", err)
+			return "", fmt.Errorf("failed to download kubeconfig: %w", err)
 		}
 
 		cm.log.Info("Kubeconfig downloaded successfully to: %s", localPath)
@@ -274,9 +306,9 @@ func (cm *ClusterManager) getEnvironment() (*config.Environment, error) {
 }
 
 func (cm *ClusterManager) getClientset(kubeconfigPath string) (*kubernetes.Clientset, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	config, err := cm.restConfig(kubeconfigPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build config: %v", err)
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
@@ -288,9 +320,9 @@ func (cm *ClusterManager) getClientset(kubeconfigPath string) (*kubernetes.Clien
 }
 
 func (cm *ClusterManager) getDynamicClient(kubeconfigPath string) (*dynamic.DynamicClient, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	config, err := cm.restConfig(kubeconfigPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build config: %v", err)
+		return nil, err
 	}
 
 	c, err := dynamic.NewForConfig(config)
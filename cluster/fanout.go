@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/logger"
+)
+
+// DefaultFanOutWorkers bounds how many clusters FanOut runs a command against
+// at once.
+const DefaultFanOutWorkers = 8
+
+// fanOutWaitDelay bounds how long a killed command may keep its output open,
+// through processes that escaped its process group.
+const fanOutWaitDelay = 5 * time.Second
+
+// Target is a cluster of an environment a command fans out to.
+type Target struct {
+	EnvID     string
+	ClusterID string
+}
+
+func (t Target) String() string {
+	return ContextName(t.EnvID, t.ClusterID)
+}
+
+// ExecResult is the outcome of running a command against one cluster.
+// ExitCode is -1 when the command could not run, Err telling why.
+type ExecResult struct {
+	Target
+	Output   []byte
+	ExitCode int
+	Err      error
+	Duration time.Duration
+}
+
+// FanOut runs argv against every target with KUBECONFIG pointing at the
+// target's cluster, at most workers at a time, each bounded by timeout when
+// non-zero. Cancelling ctx kills the running commands and skips the others.
+// onResult, if set, is called from the workers as each target finishes; the
+// results are returned in target order.
+func FanOut(ctx context.Context, cfg *config.Config, log *logger.Logger, targets []Target, argv []string, workers int, timeout time.Duration, onResult func(ExecResult)) []ExecResult {
+	if workers <= 0 {
+		workers = DefaultFanOutWorkers
+	}
+
+	// One manager per environment, so its clusters share one bastion tunnel
+	managers := make(map[string]*ClusterManager)
+	for _, target := range targets {
+		if _, ok := managers[target.EnvID]; !ok {
+			managers[target.EnvID] = NewClusterManager(target.EnvID, cfg, log)
+		}
+	}
+	defer func() {
+		for _, cm := range managers {
+			cm.Close()
+		}
+	}()
+
+	return fanOut(ctx, targets, workers, timeout, func(ctx context.Context, target Target) ExecResult {
+		return managers[target.EnvID].execTarget(ctx, target, argv)
+	}, onResult)
+}
+
+// fanOut calls run for every target, at most workers at a time, each with a
+// context bounded by timeout when non-zero. A target whose run fails once its
+// context is done is reported as timed out or canceled.
+func fanOut(ctx context.Context, targets []Target, workers int, timeout time.Duration, run func(context.Context, Target) ExecResult, onResult func(ExecResult)) []ExecResult {
+	results := make([]ExecResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results[j] = runTarget(ctx, targets[j], timeout, run)
+				if onResult != nil {
+					onResult(results[j])
+				}
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+var errCanceled = errors.New("canceled")
+
+func runTarget(parent context.Context, target Target, timeout time.Duration, run func(context.Context, Target) ExecResult) (result ExecResult) {
+	if parent.Err() != nil {
+		return ExecResult{Target: target, ExitCode: -1, Err: errCanceled}
+	}
+
+	ctx := parent
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	result = run(ctx, target)
+	result.Target = target
+	result.Duration = time.Since(start)
+
+	switch {
+	case result.Err == nil && result.ExitCode == 0:
+	case parent.Err() != nil:
+		result.ExitCode, result.Err = -1, errCanceled
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ExitCode, result.Err = -1, errors.New("timed out after "+timeout.String())
+	}
+	return result
+}
+
+// execTarget runs argv with KUBECONFIG pointing at the cluster of target,
+// killing it once ctx is done.
+func (cm *ClusterManager) execTarget(ctx context.Context, target Target, argv []string) ExecResult {
+	result := ExecResult{Target: target, ExitCode: -1}
+
+	kubeconfigPath, err := cm.GetKubeconfig(target.ClusterID)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	kubeconfigPath, err = cm.LaunchKubeconfig(kubeconfigPath)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(cm.LaunchEnv(), "KUBECONFIG="+kubeconfigPath, ContextEnv+"="+target.String())
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = fanOutWaitDelay
+	killProcessGroup(cmd)
+
+	cm.log.Info("Running %v against %s", argv, target)
+	err = cmd.Run()
+	result.Output = output.Bytes()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		result.Err = err
+	}
+	return result
+}
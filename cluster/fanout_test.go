@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fanOutTargets = []Target{{EnvID: "dev", ClusterID: "c1"}, {EnvID: "dev", ClusterID: "c2"}, {EnvID: "prod", ClusterID: "c1"}, {EnvID: "prod", ClusterID: "c2"}}
+
+// waitForCancel runs like a command killed once ctx is done.
+func waitForCancel(ctx context.Context) ExecResult {
+	<-ctx.Done()
+	return ExecResult{ExitCode: -1, Err: errors.New("signal: killed")}
+}
+
+func TestFanOutOrderAndAggregation(t *testing.T) {
+	exitCodes := map[Target]int{fanOutTargets[1]: 2}
+	delays := make(map[Target]time.Duration)
+	for i, target := range fanOutTargets {
+		delays[target] = time.Duration(len(fanOutTargets)-i) * 10 * time.Millisecond
+	}
+	var mu sync.Mutex
+	var running, maxRunning int
+	var reported []Target
+
+	results := fanOut(context.Background(), fanOutTargets, 2, 0, func(ctx context.Context, target Target) ExecResult {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		// The first targets finish last
+		time.Sleep(delays[target])
+		return ExecResult{Output: []byte(target.String()), ExitCode: exitCodes[target]}
+	}, func(result ExecResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		reported = append(reported, result.Target)
+	})
+
+	if len(results) != len(fanOutTargets) || len(reported) != len(fanOutTargets) {
+		t.Fatalf("got %d results and %d reported, want %d", len(results), len(reported), len(fanOutTargets))
+	}
+	for i, result := range results {
+		if result.Target != fanOutTargets[i] || string(result.Output) != fanOutTargets[i].String() {
+			t.Errorf("result %d is for %s with output %q, want %s", i, result.Target, result.Output, fanOutTargets[i])
+		}
+		if result.ExitCode != exitCodes[result.Target] || result.Err != nil {
+			t.Errorf("%s exited %d, %v, want %d", result.Target, result.ExitCode, result.Err, exitCodes[result.Target])
+		}
+	}
+	if maxRunning > 2 {
+		t.Errorf("%d targets ran at once, want at most 2", maxRunning)
+	}
+}
+
+func TestFanOutTimeout(t *testing.T) {
+	slow := fanOutTargets[0]
+	results := fanOut(context.Background(), fanOutTargets[:2], 2, 50*time.Millisecond, func(ctx context.Context, target Target) ExecResult {
+		if target == slow {
+			return waitForCancel(ctx)
+		}
+		return ExecResult{}
+	}, nil)
+
+	if err := results[0].Err; err == nil || err.Error() != "timed out after 50ms" || results[0].ExitCode != -1 {
+		t.Errorf("slow target = %d, %v, want timed out", results[0].ExitCode, err)
+	}
+	if results[0].Duration < 50*time.Millisecond {
+		t.Errorf("slow target took %v, less than the timeout", results[0].Duration)
+	}
+	if results[1].Err != nil || results[1].ExitCode != 0 {
+		t.Errorf("other target = %d, %v, want it unaffected by the timeout", results[1].ExitCode, results[1].Err)
+	}
+}
+
+func TestFanOutCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran []Target
+
+	results := fanOut(ctx, fanOutTargets, 1, time.Minute, func(ctx context.Context, target Target) ExecResult {
+		ran = append(ran, target)
+		cancel()
+		return waitForCancel(ctx)
+	}, nil)
+
+	if want := fanOutTargets[:1]; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want only %v before the cancel", ran, want)
+	}
+	for _, result := range results {
+		if result.Err != errCanceled || result.ExitCode != -1 {
+			t.Errorf("%s = %d, %v, want canceled", result.Target, result.ExitCode, result.Err)
+		}
+	}
+}
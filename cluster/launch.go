@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/jd/devctl/config"
+)
+
+// LaunchData is what launcher command templates are executed with.
+type LaunchData struct {
+	Kubeconfig string
+	EnvID      string
+	ClusterID  string
+	ApiServer  string
+}
+
+// LaunchCommand prepares launcher to run against info. The caller wires
+// stdio; API server tunnels stay open until Close.
+func (cm *ClusterManager) LaunchCommand(launcher config.Launcher, info ClusterInfo) (*exec.Cmd, error) {
+	tmpl, err := template.New(launcher.Name).Option("missingkey=error").Parse(launcher.Command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command of launcher %s: %v", launcher.Name, err)
+	}
+
+	kubeconfigPath, err := cm.GetKubeconfig(info.ID)
+	if err != nil {
+		return nil, err
+	}
+	kubeconfigPath, err = cm.LaunchKubeconfig(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	shell, flag, quote := launchShell()
+	var command bytes.Buffer
+	err = tmpl.Execute(&command, LaunchData{
+		Kubeconfig: quote(kubeconfigPath),
+		EnvID:      quote(cm.EnvID),
+		ClusterID:  quote(info.ID),
+		ApiServer:  quote(info.ApiServer),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid command of launcher %s: %v", launcher.Name, err)
+	}
+
+	cm.log.Info("Launching %s for cluster %s: %s", launcher.Name, info.ID, command.String())
+	cmd := exec.Command(shell, flag, command.String())
+	cmd.Env = append(cm.LaunchEnv(), launcher.Env...)
+	return cmd, nil
+}
+
+// ShellArgs returns the argv running command through the shell.
+func ShellArgs(command string) []string {
+	shell, flag, _ := launchShell()
+	return []string{shell, flag, command}
+}
+
+// launchShell returns the shell running launcher commands and how to quote
+// values for it.
+func launchShell() (string, string, func(string) string) {
+	if runtime.GOOS == "windows" {
+		return "cmd.exe", "/C", func(s string) string {
+			return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+		}
+	}
+	return "/bin/sh", "-c", func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+}
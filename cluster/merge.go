@@ -0,0 +1,290 @@
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/logger"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// MergeResult is the outcome of merging one cluster. Err is set when the
+// cluster, or with an empty ClusterID the whole environment, was unreachable.
+// Skipped tells why an environment was left out.
+type MergeResult struct {
+	EnvID     string
+	ClusterID string
+	Err       error
+	Skipped   string
+}
+
+// DefaultMergedKubeconfigPath is where MergeKubeconfigs writes by default.
+func DefaultMergedKubeconfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".devctl", "kubeconfig")
+}
+
+// MergeKubeconfigs writes one kubeconfig with a context per cluster of the
+// given environments, all of them when envIDs is empty. Clusters, users and
+// contexts are renamed to <envID>/<clusterID> so entries from different
+// clusters never collide. An existing file at path is updated in place:
+// entries of unreachable clusters are kept, entries of clusters that no longer
+// exist are dropped. Environments whose clusters cannot be reached without
+// devctl are skipped, the default one unless asked for without a result.
+func MergeKubeconfigs(cfg *config.Config, log *logger.Logger, envIDs []string, path string) ([]MergeResult, error) {
+	merged, err := loadKubeconfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []MergeResult
+	for _, env := range cfg.Envs {
+		if len(envIDs) > 0 && !contains(envIDs, env.ID) {
+			continue
+		}
+		if reason := unmergeable(env); reason != "" {
+			if len(envIDs) > 0 || env.ID != "default" {
+				log.Info("Skipping %s in merged kubeconfig: %s", env.ID, reason)
+				results = append(results, MergeResult{EnvID: env.ID, Skipped: reason})
+			}
+			continue
+		}
+		results = append(results, mergeEnvironment(cfg, log, env, merged)...)
+	}
+
+	if err := writeKubeconfig(merged, path); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// unmergeable tells why the clusters of env cannot be used from a standalone
+// kubeconfig, or returns "" when they can.
+func unmergeable(env config.Environment) string {
+	switch {
+	case env.ID == "default":
+		return "the default environment is the local kubeconfig"
+	case env.TunnelAPIServer:
+		return "API servers are only reachable through the devctl SSH tunnel"
+	default:
+		return ""
+	}
+}
+
+func mergeEnvironment(cfg *config.Config, log *logger.Logger, env config.Environment, merged *clientcmdapi.Config) []MergeResult {
+	cm := NewClusterManager(env.ID, cfg, log)
+	defer cm.Close()
+
+	clusterIDs, err := cm.ListClusterSecrets()
+	if err != nil {
+		return []MergeResult{{EnvID: env.ID, Err: err}}
+	}
+	return mergeClusters(log, env, clusterIDs, cm.loadClusterKubeconfig, merged)
+}
+
+// mergeClusters merges the kubeconfig load returns for each of clusterIDs,
+// the clusters env now has, dropping those of its clusters that are gone.
+func mergeClusters(log *logger.Logger, env config.Environment, clusterIDs []string, load func(clusterID string) (*clientcmdapi.Config, error), merged *clientcmdapi.Config) []MergeResult {
+	removeStale(merged, env.ID, clusterIDs)
+
+	var results []MergeResult
+	for _, clusterID := range clusterIDs {
+		source, err := load(clusterID)
+		if err == nil {
+			err = mergeCluster(env, clusterID, source, merged)
+		}
+		if err != nil {
+			log.Error("Failed to merge kubeconfig of %s: %v", ContextName(env.ID, clusterID), err)
+		}
+		results = append(results, MergeResult{EnvID: env.ID, ClusterID: clusterID, Err: err})
+	}
+	return results
+}
+
+func (cm *ClusterManager) loadClusterKubeconfig(clusterID string) (*clientcmdapi.Config, error) {
+	kubeconfigPath, err := cm.GetKubeconfig(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	source, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	return source, nil
+}
+
+// mergeCluster adds the contexts of source, the kubeconfig of clusterID, to
+// merged, replacing those merged before.
+func mergeCluster(env config.Environment, clusterID string, source, merged *clientcmdapi.Config) error {
+	// The current context keeps the plain name, others are suffixed
+	current := source.CurrentContext
+	if len(source.Contexts) == 1 {
+		for contextName := range source.Contexts {
+			current = contextName
+		}
+	}
+	if context, ok := source.Contexts[current]; !ok || source.Clusters[context.Cluster] == nil {
+		return fmt.Errorf("kubeconfig has %d contexts and no usable current-context", len(source.Contexts))
+	}
+
+	name := ContextName(env.ID, clusterID)
+	removeEntries(merged, name)
+
+	for contextName, context := range source.Contexts {
+		cluster, ok := source.Clusters[context.Cluster]
+		if !ok {
+			continue
+		}
+
+		mergedName := name
+		if contextName != current {
+			mergedName = name + "/" + contextName
+		}
+
+		cluster = cluster.DeepCopy()
+		if env.Proxy != "" {
+			cluster.ProxyURL = env.Proxy
+		}
+		merged.Clusters[mergedName] = cluster
+		if user, ok := source.AuthInfos[context.AuthInfo]; ok {
+			merged.AuthInfos[mergedName] = user.DeepCopy()
+		}
+
+		context = context.DeepCopy()
+		context.Cluster = mergedName
+		context.AuthInfo = mergedName
+		merged.Contexts[mergedName] = context
+	}
+	return nil
+}
+
+// removeStale drops the entries of envID whose cluster is not in clusterIDs.
+func removeStale(merged *clientcmdapi.Config, envID string, clusterIDs []string) {
+	for name := range merged.Contexts {
+		contextEnv, rest, ok := strings.Cut(name, "/")
+		if !ok || contextEnv != envID {
+			continue
+		}
+		clusterID, _, _ := strings.Cut(rest, "/")
+		if !contains(clusterIDs, clusterID) {
+			removeEntries(merged, ContextName(envID, clusterID))
+		}
+	}
+}
+
+// removeEntries deletes name and its suffixed variants from merged.
+func removeEntries(merged *clientcmdapi.Config, name string) {
+	matches := func(key string) bool {
+		return key == name || strings.HasPrefix(key, name+"/")
+	}
+	for key := range merged.Clusters {
+		if matches(key) {
+			delete(merged.Clusters, key)
+		}
+	}
+	for key := range merged.AuthInfos {
+		if matches(key) {
+			delete(merged.AuthInfos, key)
+		}
+	}
+	for key := range merged.Contexts {
+		if matches(key) {
+			delete(merged.Contexts, key)
+		}
+	}
+}
+
+func loadKubeconfig(path string) (*clientcmdapi.Config, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return clientcmdapi.NewConfig(), nil
+	}
+	kubeconfig, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+	return kubeconfig, nil
+}
+
+// writeKubeconfig replaces path atomically so readers never see a partial
+// file. A symlinked path, such as a ~/.kube/config kept in a dotfiles
+// repository, has its target replaced instead.
+func writeKubeconfig(kubeconfig *clientcmdapi.Config, path string) error {
+	// A current context whose cluster was dropped would break kubectl
+	if _, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]; !ok {
+		kubeconfig.CurrentContext = ""
+	}
+
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to encode kubeconfig: %v", err)
+	}
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to resolve %s: %v", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write kubeconfig: %v", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write kubeconfig: %v", err)
+	}
+	return nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// SwitchContext merges the kubeconfig of clusterID into the kubeconfig at path
+// as the <envID>/<clusterID> context and makes it the current context.
+func (cm *ClusterManager) SwitchContext(clusterID, path string) (string, error) {
+	env, err := cm.getEnvironment()
+	if err != nil {
+		return "", err
+	}
+	if reason := unmergeable(*env); reason != "" {
+		return "", fmt.Errorf("cannot add %s to %s: %s", ContextName(cm.EnvID, clusterID), path, reason)
+	}
+
+	kubeconfig, err := loadKubeconfig(path)
+	if err != nil {
+		return "", err
+	}
+	source, err := cm.loadClusterKubeconfig(clusterID)
+	if err != nil {
+		return "", err
+	}
+	if err := mergeCluster(*env, clusterID, source, kubeconfig); err != nil {
+		return "", err
+	}
+
+	name := ContextName(cm.EnvID, clusterID)
+	kubeconfig.CurrentContext = name
+	if err := writeKubeconfig(kubeconfig, path); err != nil {
+		return "", err
+	}
+	return name, nil
+}
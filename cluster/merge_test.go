@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/logger"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func newTestLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.NewLogger(logger.DEBUG, filepath.Join(t.TempDir(), "devctl.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(log.Close)
+	return log
+}
+
+// kubeconfig returns a kubeconfig with a context, cluster and user of each
+// name, all on server. The first context is the current one.
+func kubeconfig(server string, contexts ...string) *clientcmdapi.Config {
+	c := clientcmdapi.NewConfig()
+	for _, name := range contexts {
+		c.Clusters[name+"-cluster"] = &clientcmdapi.Cluster{Server: server}
+		c.AuthInfos[name+"-user"] = &clientcmdapi.AuthInfo{Token: name}
+		c.Contexts[name] = &clientcmdapi.Context{Cluster: name + "-cluster", AuthInfo: name + "-user"}
+	}
+	if len(contexts) > 0 {
+		c.CurrentContext = contexts[0]
+	}
+	return c
+}
+
+func contextNames(c *clientcmdapi.Config) []string {
+	var names []string
+	for name := range c.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMergeClusterRenames(t *testing.T) {
+	merged := clientcmdapi.NewConfig()
+	env := config.Environment{ID: "dev", Proxy: "socks5://127.0.0.1:1080"}
+	source := kubeconfig("https://10.0.0.1:6443", "kubernetes-admin@c1", "readonly")
+	source.CurrentContext = "readonly"
+
+	if err := mergeCluster(env, "c1", source, merged); err != nil {
+		t.Fatalf("mergeCluster: %v", err)
+	}
+
+	if got, want := contextNames(merged), []string{"dev/c1", "dev/c1/kubernetes-admin@c1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("contexts = %v, want %v", got, want)
+	}
+	for _, name := range []string{"dev/c1", "dev/c1/kubernetes-admin@c1"} {
+		context := merged.Contexts[name]
+		if context.Cluster != name || context.AuthInfo != name {
+			t.Errorf("context %s uses cluster %s and user %s, want both renamed", name, context.Cluster, context.AuthInfo)
+		}
+		if cluster := merged.Clusters[name]; cluster == nil || cluster.ProxyURL != env.Proxy {
+			t.Errorf("cluster %s = %+v, want the environment proxy", name, cluster)
+		}
+	}
+	if token := merged.AuthInfos["dev/c1"].Token; token != "readonly" {
+		t.Errorf("dev/c1 user token = %q, want that of the current context", token)
+	}
+	if source.Clusters["readonly-cluster"].ProxyURL != "" {
+		t.Error("mergeCluster changed the source kubeconfig")
+	}
+}
+
+func TestMergeClusterWithoutCurrentContext(t *testing.T) {
+	merged := kubeconfig("https://old", "dev/c1")
+	source := kubeconfig("https://10.0.0.1:6443", "a", "b")
+	source.CurrentContext = ""
+
+	err := mergeCluster(config.Environment{ID: "dev"}, "c1", source, merged)
+	if err == nil {
+		t.Fatal("mergeCluster picked one of several contexts")
+	}
+	if merged.Contexts["dev/c1"] == nil {
+		t.Error("the entries merged before were removed")
+	}
+}
+
+func TestMergeClustersRerun(t *testing.T) {
+	log := newTestLogger(t)
+	dev := config.Environment{ID: "dev"}
+	merged := kubeconfig("https://other", "prod/c2", "minikube")
+
+	sources := map[string]*clientcmdapi.Config{
+		"c1": kubeconfig("https://10.0.0.1:6443", "admin"),
+		"c2": kubeconfig("https://10.0.0.2:6443", "admin"),
+	}
+	load := func(clusterID string) (*clientcmdapi.Config, error) {
+		if source, ok := sources[clusterID]; ok {
+			return source, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	mergeClusters(log, dev, []string{"c1", "c2"}, load, merged)
+	if got, want := contextNames(merged), []string{"dev/c1", "dev/c2", "minikube", "prod/c2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first run contexts = %v, want %v", got, want)
+	}
+
+	// c2 was deleted, c1 moved and c3 cannot be reached
+	sources["c1"] = kubeconfig("https://10.0.1.1:6443", "admin")
+	results := mergeClusters(log, dev, []string{"c1", "c3"}, load, merged)
+	if got, want := contextNames(merged), []string{"dev/c1", "minikube", "prod/c2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second run contexts = %v, want %v", got, want)
+	}
+	if server := merged.Clusters["dev/c1"].Server; server != "https://10.0.1.1:6443" {
+		t.Errorf("dev/c1 server = %s, want the new one", server)
+	}
+	if len(results) != 2 || results[0].Err != nil || results[1].ClusterID != "c3" || results[1].Err == nil {
+		t.Errorf("results = %+v, want c1 merged and c3 unreachable", results)
+	}
+
+	// An unreachable cluster keeps what was merged for it before
+	delete(sources, "c1")
+	results = mergeClusters(log, dev, []string{"c1"}, load, merged)
+	if merged.Contexts["dev/c1"] == nil || results[0].Err == nil {
+		t.Errorf("dev/c1 dropped while unreachable, results = %+v", results)
+	}
+}
+
+func TestMergeKubeconfigsSkips(t *testing.T) {
+	cfg := &config.Config{Envs: []config.Environment{
+		{ID: "default", Kubeconfig: "/tmp/kubeconfig"},
+		{ID: "tunneled", TunnelAPIServer: true},
+	}}
+	path := filepath.Join(t.TempDir(), "kube", "config")
+
+	results, err := MergeKubeconfigs(cfg, newTestLogger(t), nil, path)
+	if err != nil {
+		t.Fatalf("MergeKubeconfigs: %v", err)
+	}
+	if len(results) != 1 || results[0].EnvID != "tunneled" || results[0].Skipped == "" {
+		t.Errorf("results = %+v, want only the tunneled environment skipped", results)
+	}
+	if _, err := clientcmd.LoadFromFile(path); err != nil {
+		t.Errorf("merged kubeconfig not written: %v", err)
+	}
+
+	// The default environment is only reported when asked for
+	results, err = MergeKubeconfigs(cfg, newTestLogger(t), []string{"default"}, path)
+	if err != nil {
+		t.Fatalf("MergeKubeconfigs: %v", err)
+	}
+	if len(results) != 1 || results[0].EnvID != "default" || results[0].Skipped == "" {
+		t.Errorf("results = %+v, want the default environment skipped", results)
+	}
+}
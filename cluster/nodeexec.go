@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jd/devctl/ssh"
+)
+
+// DefaultNodeExecWorkers bounds how many nodes RunOnNodes connects to at once.
+const DefaultNodeExecWorkers = 10
+
+// NodeResult is the outcome of running a command on one node. ExitCode is -1
+// when the command could not run, Err telling why.
+type NodeResult struct {
+	Node     NodeInfo
+	Output   []byte
+	ExitCode int
+	Err      error
+	Duration time.Duration
+}
+
+// RunOnNodes runs command over SSH on every node of clusterID, at most workers
+// at a time, each bounded by timeout when non-zero. The nodes are all dialed
+// through one connection to the jump host. acceptHostKey, if set, decides
+// whether to trust a node's unknown host key, otherwise such nodes fail with
+// ssh.UnknownHostKeyError. onLine, if set, receives each line of output as it
+// arrives and onResult each node as it finishes, all called from the workers.
+// The results are returned in node order. Cancelling ctx closes the sessions
+// still running and skips the other nodes.
+func (cm *ClusterManager) RunOnNodes(ctx context.Context, clusterID string, nodes []NodeInfo, command string, workers int, timeout time.Duration, acceptHostKey func(NodeInfo, *ssh.UnknownHostKeyError) bool, onLine func(NodeInfo, string), onResult func(NodeResult)) ([]NodeResult, error) {
+	env, err := cm.getEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	store, err := cm.Config.CredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = DefaultNodeExecWorkers
+	}
+
+	bastion, err := ssh.NewSSHClientForEnv(*env, store).Connect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to jump host %s: %w", env.IP, err)
+	}
+	defer bastion.Close()
+
+	// Closing the jump host connection also aborts the nodes still dialing
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			bastion.Close()
+		case <-done:
+		}
+	}()
+
+	results := make([]NodeResult, len(nodes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				node := nodes[j]
+				if ctx.Err() != nil {
+					results[j] = NodeResult{Node: node, ExitCode: -1, Err: errCanceled}
+					if onResult != nil {
+						onResult(results[j])
+					}
+					continue
+				}
+				client := ssh.NewSSHClientForNodeVia(*env, clusterID, node.IP, bastion, store)
+				if acceptHostKey != nil {
+					client.AcceptHostKey = func(keyErr *ssh.UnknownHostKeyError) bool {
+						return acceptHostKey(node, keyErr)
+					}
+				}
+				results[j] = cm.runOnNode(ctx, client, node, command, timeout, onLine)
+				if onResult != nil {
+					onResult(results[j])
+				}
+			}
+		}()
+	}
+	for i := range nodes {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, nil
+}
+
+func (cm *ClusterManager) runOnNode(parent context.Context, client *ssh.SSHClient, node NodeInfo, command string, timeout time.Duration, onLine func(NodeInfo, string)) (result NodeResult) {
+	result = NodeResult{Node: node, ExitCode: -1}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+	}()
+
+	ctx := parent
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	output := &lineWriter{onLine: func(line string) {
+		if onLine != nil {
+			onLine(node, line)
+		}
+	}}
+
+	cm.log.Info("Running %q on node %s (%s)", command, node.Name, node.IP)
+	exitCode, err := client.Run(ctx, command, output)
+	output.Flush()
+	result.Output = output.Bytes()
+
+	switch {
+	case err != nil && parent.Err() != nil:
+		result.Err = errCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		result.Err = errors.New("timed out after " + timeout.String())
+	case err != nil:
+		cm.log.Error("Failed to run command on node %s: %v", node.IP, err)
+		result.Err = err
+	default:
+		result.ExitCode = exitCode
+	}
+	return result
+}
+
+// lineWriter collects everything written to it and passes each complete line
+// to onLine. The session writes stdout and stderr from separate goroutines.
+type lineWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	pending []byte
+	onLine  func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(string(bytes.TrimSuffix(w.pending[:i], []byte("\r"))))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush passes on the last line when it has no trailing newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 {
+		w.onLine(string(w.pending))
+		w.pending = nil
+	}
+}
+
+func (w *lineWriter) Bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Bytes()
+}
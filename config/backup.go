@@ -0,0 +1,362 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jd/devctl/logger"
+)
+
+// DefaultBackupKeep is how many backups PruneBackups keeps when the config
+// sets no retention policy.
+const DefaultBackupKeep = 50
+
+const backupTimeLayout = "20060102_150405"
+
+// BackupPolicy bounds the backups SaveConfig leaves in ~/.devctl/backups. A
+// zero Keep or KeepDays disables that limit. Without a policy, backups are
+// only removed by PruneBackups.
+type BackupPolicy struct {
+	Keep     int `yaml:"keep,omitempty"`
+	KeepDays int `yaml:"keepDays,omitempty"`
+}
+
+// Backup is a copy of the config file written by BackupConfig. Err is set
+// when the copy cannot be parsed, in which case EnvIDs is empty.
+type Backup struct {
+	Name   string
+	Path   string
+	Time   time.Time
+	EnvIDs []string
+	Err    error
+
+	// seq numbers the backups taken within the same second, from 2.
+	seq int
+}
+
+// EnvDiff is how an environment differs between two configs. Kind is
+// "added", "removed" or "changed"; only changes list fields.
+type EnvDiff struct {
+	ID     string
+	Kind   string
+	Fields []FieldDiff
+}
+
+// FieldDiff is a changed environment field, named by its YAML key. Secrets
+// are masked and jump hosts and node logins are summarized by their count.
+type FieldDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func configPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".devctl", "config.yaml"), nil
+}
+
+func backupDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), "backups")
+}
+
+// ListBackups returns the config backups with their environments, newest
+// first.
+func ListBackups() ([]Backup, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	backups, err := listBackupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range backups {
+		if config, err := backups[i].Load(); err != nil {
+			backups[i].Err = err
+		} else {
+			for _, env := range config.Envs {
+				backups[i].EnvIDs = append(backups[i].EnvIDs, env.ID)
+			}
+		}
+	}
+	return backups, nil
+}
+
+// ListBackupFiles is like ListBackups but does not parse the backups, leaving
+// their EnvIDs and Err unset.
+func ListBackupFiles() ([]Backup, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	return listBackupFiles(path)
+}
+
+func listBackupFiles(configPath string) ([]Backup, error) {
+	paths, err := filepath.Glob(filepath.Join(backupDir(configPath), "config_*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, path := range paths {
+		name := filepath.Base(path)
+		backup := Backup{Name: name, Path: path}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, "config_"), ".yaml")
+		if i := len(backupTimeLayout); len(stamp) > i && stamp[i] == '_' {
+			if backup.seq, err = strconv.Atoi(stamp[i+1:]); err == nil {
+				stamp = stamp[:i]
+			}
+		}
+		if backup.Time, err = time.ParseInLocation(backupTimeLayout, stamp, time.Local); err != nil {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			backup.Time = info.ModTime()
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].Time.Equal(backups[j].Time) {
+			return backups[i].seq > backups[j].seq
+		}
+		return backups[i].Time.After(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// FindBackup returns the backup named name, given either as its file name or
+// its timestamp such as 20240102_150405.
+func FindBackup(name string) (Backup, error) {
+	backups, err := ListBackupFiles()
+	if err != nil {
+		return Backup{}, err
+	}
+	for _, backup := range backups {
+		if backup.Name == name || backup.Name == "config_"+name+".yaml" {
+			return backup, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("backup %s not found", name)
+}
+
+// Load parses the backup.
+func (b Backup) Load() (*Config, error) {
+	data, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := decodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse backup %s: %v", b.Name, err)
+	}
+	return config, nil
+}
+
+// RestoreBackup replaces the config file with the backup and reloads c from
+// it, first backing up the current file so the restore itself can be undone.
+func (c *Config) RestoreBackup(b Backup, log *logger.Logger) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	log.Info("Restoring config from backup: %s", b.Path)
+
+	data, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		log.Error("Failed to read backup: %v", err)
+		return err
+	}
+	restored, err := b.Load()
+	if err != nil {
+		log.Error("Refusing to restore backup: %v", err)
+		return err
+	}
+
+	unlock, err := lockConfig(path)
+	if err != nil {
+		log.Error("Failed to lock config: %v", err)
+		return err
+	}
+	defer unlock()
+
+	if err := BackupConfig(path, log); err != nil {
+		return fmt.Errorf("failed to backup config: %v", err)
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		log.Error("Failed to write config file: %v", err)
+		return err
+	}
+	restored.loaded = data
+
+	// Keep the unlocked master key so restoring never prompts for it again
+	if restored.Secrets != nil && c.Secrets != nil && *restored.Secrets == *c.Secrets {
+		restored.sealer = c.sealer
+	}
+	*c = *c.Compose(restored)
+
+	log.Info("Config restored from %s", b.Name)
+	return nil
+}
+
+// PruneBackups deletes the backups exceeding the config's retention policy,
+// keeping DefaultBackupKeep when it sets none.
+func (c *Config) PruneBackups(log *logger.Logger) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	policy := BackupPolicy{Keep: DefaultBackupKeep}
+	if c.Backups != nil {
+		policy = *c.Backups
+	}
+	return c.pruneBackups(path, policy, log)
+}
+
+func (c *Config) pruneBackups(configPath string, policy BackupPolicy, log *logger.Logger) error {
+	backups, err := listBackupFiles(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Secrets of deleted environments are kept for as long as a backup
+	// references them, so that restoring it brings them back
+	refs := make(map[string]bool)
+	var kept []Backup
+	cutoff := time.Now().AddDate(0, 0, -policy.KeepDays)
+	for i, backup := range backups {
+		expired := policy.KeepDays > 0 && backup.Time.Before(cutoff)
+		if (policy.Keep == 0 || i < policy.Keep) && !expired {
+			kept = append(kept, backup)
+			continue
+		}
+
+		if config, err := backup.Load(); err == nil {
+			for ref := range config.secretRefs() {
+				refs[ref] = true
+			}
+		}
+		log.Info("Removing old config backup: %s", backup.Path)
+		if err := os.Remove(backup.Path); err != nil {
+			return err
+		}
+	}
+	if len(refs) == 0 {
+		return nil
+	}
+
+	for ref := range c.secretRefs() {
+		delete(refs, ref)
+	}
+	for _, backup := range kept {
+		config, err := backup.Load()
+		if err != nil {
+			// Whatever it references may still be needed
+			return nil
+		}
+		for ref := range config.secretRefs() {
+			delete(refs, ref)
+		}
+	}
+	for ref := range refs {
+		log.Info("Removing secret no longer referenced by any backup: %s", ref)
+		if err := c.forgetSecret(ref); err != nil {
+			log.Warning("Failed to remove secret %s: %v", ref, err)
+		}
+	}
+	return nil
+}
+
+// DiffConfigs returns how the environments of to differ from those of from.
+func DiffConfigs(from, to *Config) []EnvDiff {
+	var diffs []EnvDiff
+	for _, old := range from.Envs {
+		updated, ok := findEnv(to.Envs, old.ID)
+		if !ok {
+			diffs = append(diffs, EnvDiff{ID: old.ID, Kind: "removed"})
+			continue
+		}
+		if fields := diffEnvironments(old, updated); len(fields) > 0 {
+			diffs = append(diffs, EnvDiff{ID: old.ID, Kind: "changed", Fields: fields})
+		}
+	}
+	for _, env := range to.Envs {
+		if _, ok := findEnv(from.Envs, env.ID); !ok {
+			diffs = append(diffs, EnvDiff{ID: env.ID, Kind: "added"})
+		}
+	}
+	return diffs
+}
+
+// String renders the diff prefixed with + for an added environment, - for a
+// removed one and ~ for a changed one, followed by its changed fields.
+func (d EnvDiff) String() string {
+	switch d.Kind {
+	case "added":
+		return fmt.Sprintf("+ %s\n", d.ID)
+	case "removed":
+		return fmt.Sprintf("- %s\n", d.ID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "~ %s\n", d.ID)
+	for _, field := range d.Fields {
+		fmt.Fprintf(&b, "    %s: %q -> %q\n", field.Field, field.Old, field.New)
+	}
+	return b.String()
+}
+
+func findEnv(envs []Environment, id string) (Environment, bool) {
+	for _, env := range envs {
+		if env.ID == id {
+			return env, true
+		}
+	}
+	return Environment{}, false
+}
+
+func diffEnvironments(old, updated Environment) []FieldDiff {
+	var fields []FieldDiff
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(updated)
+	for i := 0; i < oldValue.NumField(); i++ {
+		a, b := oldValue.Field(i).Interface(), newValue.Field(i).Interface()
+		name := strings.Split(oldValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "-" || reflect.DeepEqual(a, b) {
+			continue
+		}
+		fields = append(fields, FieldDiff{Field: name, Old: diffValue(name, a), New: diffValue(name, b)})
+	}
+	return fields
+}
+
+func diffValue(field string, value interface{}) string {
+	v := reflect.ValueOf(value)
+	switch {
+	case field == "password" || field == "keyPassphrase":
+		if v.Len() == 0 {
+			return ""
+		}
+		return "***"
+	case v.Type() == reflect.TypeOf(time.Time{}):
+		return FormatTime(value.(time.Time))
+	case v.Type() == reflect.TypeOf([]string(nil)):
+		return strings.Join(value.([]string), ",")
+	case v.Kind() == reflect.Slice:
+		return fmt.Sprintf("%d entries", v.Len())
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jd/devctl/credential"
+)
+
+func TestPruneBackupsForgetsUnreferencedSecrets(t *testing.T) {
+	withDev := "version: 1\nenvs:\n- {id: dev, ip: 10.0.0.1, user: root, password: 'file:dev'}\n"
+	withoutDev := "version: 1\nenvs: []\n"
+	dev := Environment{ID: "dev", IP: "10.0.0.1", User: "root", Password: "file:dev"}
+
+	tests := []struct {
+		name       string
+		backups    []string
+		envs       []Environment
+		wantSecret bool
+	}{
+		{name: "referenced by a kept backup", backups: []string{withDev, withDev}, wantSecret: true},
+		{name: "referenced by the config", backups: []string{withDev, withoutDev}, envs: []Environment{dev}, wantSecret: true},
+		{name: "no longer referenced", backups: []string{withDev, withoutDev}, wantSecret: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupHome(t)
+			c := &Config{
+				Credentials: &Credentials{Backend: credential.BackendFile},
+				Backups:     &BackupPolicy{Keep: 1},
+				Envs:        tt.envs,
+			}
+			store, err := c.CredentialStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Set("dev", "s3cret"); err != nil {
+				t.Fatal(err)
+			}
+			for i, backup := range tt.backups {
+				writeFile(t, filepath.Join(dir, "backups", fmt.Sprintf("config_2024010%d_000000.yaml", i+1)), backup)
+			}
+
+			if err := c.pruneBackups(filepath.Join(dir, "config.yaml"), *c.Backups, newTestLogger(t)); err != nil {
+				t.Fatalf("pruneBackups: %v", err)
+			}
+			if backups, _ := listBackupFiles(filepath.Join(dir, "config.yaml")); len(backups) != 1 {
+				t.Errorf("%d backups left, want 1", len(backups))
+			}
+			_, err = store.Get("file:dev")
+			if got := err == nil; got != tt.wantSecret {
+				t.Errorf("secret kept = %v, want %v (%v)", got, tt.wantSecret, err)
+			}
+		})
+	}
+}
+
+func TestSaveConfigPrunesOnlyWithPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      *BackupPolicy
+		wantBackups int
+	}{
+		{name: "no policy", wantBackups: 4},
+		{name: "keep 2", policy: &BackupPolicy{Keep: 2}, wantBackups: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupHome(t)
+			writeFile(t, filepath.Join(dir, "config.yaml"), "version: 1\nenvs: []\n")
+			for i := 1; i <= 3; i++ {
+				writeFile(t, filepath.Join(dir, "backups", fmt.Sprintf("config_2024010%d_000000.yaml", i)), "version: 1\nenvs: []\n")
+			}
+
+			c := &Config{Backups: tt.policy}
+			if err := SaveConfig(c, newTestLogger(t)); err != nil {
+				t.Fatalf("SaveConfig: %v", err)
+			}
+			backups, err := listBackupFiles(filepath.Join(dir, "config.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != tt.wantBackups {
+				t.Errorf("%d backups left, want %d", len(backups), tt.wantBackups)
+			}
+		})
+	}
+}
+
+func TestBackupConfigKeepsSameSecondBackups(t *testing.T) {
+	dir := setupHome(t)
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, filepath.Join(dir, "backups", "config_20240101_000000.yaml"), "first")
+	writeFile(t, filepath.Join(dir, "backups", "config_20240101_000000_2.yaml"), "second")
+
+	for _, content := range []string{"third", "fourth", "fifth"} {
+		writeFile(t, configPath, content)
+		if err := BackupConfig(configPath, newTestLogger(t)); err != nil {
+			t.Fatalf("BackupConfig: %v", err)
+		}
+	}
+
+	backups, err := listBackupFiles(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, backup := range backups {
+		data, err := ioutil.ReadFile(backup.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	if want := []string{"fifth", "fourth", "third", "second", "first"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("backups newest first = %v, want %v", got, want)
+	}
+	if !backups[3].Time.Equal(backups[4].Time) || backups[3].Time.IsZero() {
+		t.Errorf("numbered backup time = %v, want the same as %v", backups[3].Time, backups[4].Time)
+	}
+}
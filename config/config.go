@@ -1,58 +1,211 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/jd/devctl/credential"
 	"github.com/jd/devctl/logger"
-	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Envs []Environment `yaml:"envs"`
+	// Version is the schema version, see CurrentVersion.
+	Version     int           `yaml:"version"`
+	Secrets     *Secrets      `yaml:"secrets,omitempty"`
+	Credentials *Credentials  `yaml:"credentials,omitempty"`
+	Envs        []Environment `yaml:"envs"`
+	Launchers   []Launcher    `yaml:"launchers,omitempty"`
+	// Backups is the retention of the backups written on every save.
+	Backups *BackupPolicy `yaml:"backups,omitempty"`
+
+	sealer *credential.Sealer
+	store  credential.CredentialStore
+	// loaded is the file content the config was read from or last written
+	// as, to detect changes made by other devctl instances.
+	loaded []byte
+	// migrated is set when the file uses an older schema than Version.
+	migrated bool
+	// lower holds the environments of the system and team layers, see
+	// loadLayers, before the personal file is laid over them.
+	lower          []Environment
+	lowerLaunchers []Launcher
+	layerErrors    []error
+	// keys lists the YAML keys each environment of the file sets, by ID,
+	// which are those an environment of a lower layer overrides.
+	keys map[string][]string
 }
 
 type Environment struct {
-	ID         string `yaml:"id"`
-	Name       string `yaml:"name"`
-	CreateTime string `yaml:"createTime"`
-	UpdateTime string `yaml:"updateTime"`
-	IP         string `yaml:"ip"`
-	User       string `yaml:"user"`
-	Password   string `yaml:"password"`
-	Kubeconfig string `yaml:"kubeconfig"`
+	// Layer is the config layer, or layers, the environment comes from.
+	Layer      string    `yaml:"-"`
+	ID         string    `yaml:"id"`
+	Name       string    `yaml:"name"`
+	CreateTime time.Time `yaml:"createTime,omitempty"`
+	UpdateTime time.Time `yaml:"updateTime,omitempty"`
+	IP         string    `yaml:"ip"`
+	User       string    `yaml:"user"`
+	Password   string    `yaml:"password"`
+	Kubeconfig string    `yaml:"kubeconfig"`
+	// KeyFiles, KeyPassphrase and AuthMethods configure public-key, ssh-agent
+	// and keyboard-interactive login to the jump host.
+	KeyFiles      []string `yaml:"keyFiles,omitempty"`
+	KeyPassphrase string   `yaml:"keyPassphrase,omitempty"`
+	AuthMethods   []string `yaml:"authMethods,omitempty"`
+	// Port defaults to 22. ConnectTimeout and KeepAlive take durations such
+	// as "10s"; a zero KeepAlive disables keepalives.
+	Port           int           `yaml:"port,omitempty"`
+	ConnectTimeout time.Duration `yaml:"connectTimeout,omitempty"`
+	KeepAlive      time.Duration `yaml:"keepAlive,omitempty"`
+	// JumpHosts are traversed in order to reach IP, like ssh -J.
+	JumpHosts []JumpHost `yaml:"jumpHosts,omitempty"`
+	// NodeLogins override the credentials used for cluster nodes, which are
+	// reached through the jump host. An empty Cluster matches every cluster.
+	NodeLogins []NodeLogin `yaml:"nodeLogins,omitempty"`
+	// TunnelAPIServer reaches API servers through a port-forward over the
+	// jump host, for kubeconfigs pointing at private addresses.
+	TunnelAPIServer bool `yaml:"tunnelAPIServer,omitempty"`
+	// Proxy is a socks5:// or http:// URL API servers are reached through.
+	// It is not used for tunneled API servers.
+	Proxy string `yaml:"proxy,omitempty"`
+}
+
+// SSHAuth holds the credentials of an SSH hop. Password and KeyPassphrase may
+// be credential references.
+type SSHAuth struct {
+	User          string   `yaml:"user"`
+	Password      string   `yaml:"password,omitempty"`
+	KeyFiles      []string `yaml:"keyFiles,omitempty"`
+	KeyPassphrase string   `yaml:"keyPassphrase,omitempty"`
+	AuthMethods   []string `yaml:"authMethods,omitempty"`
+}
+
+type JumpHost struct {
+	Host    string `yaml:"host"`
+	Port    int    `yaml:"port,omitempty"`
+	SSHAuth `yaml:",inline"`
+}
+
+type NodeLogin struct {
+	Cluster string `yaml:"cluster,omitempty"`
+	Port    int    `yaml:"port,omitempty"`
+	SSHAuth `yaml:",inline"`
+}
+
+// NodeLogin returns the login for nodes of clusterID: a cluster-specific
+// entry, then a catch-all entry, then the jump host's own credentials.
+func (env Environment) NodeLogin(clusterID string) NodeLogin {
+	var fallback *NodeLogin
+	for i, login := range env.NodeLogins {
+		if login.Cluster == clusterID {
+			return login
+		}
+		if login.Cluster == "" && fallback == nil {
+			fallback = &env.NodeLogins[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback
+	}
+
+	return NodeLogin{
+		SSHAuth: SSHAuth{
+			User:          env.User,
+			Password:      env.Password,
+			KeyFiles:      env.KeyFiles,
+			KeyPassphrase: env.KeyPassphrase,
+			AuthMethods:   env.AuthMethods,
+		},
+	}
+}
+
+// SetNodeLogin adds login, replacing the existing entry for the same cluster.
+func (env *Environment) SetNodeLogin(login NodeLogin) {
+	for i := range env.NodeLogins {
+		if env.NodeLogins[i].Cluster == login.Cluster {
+			env.NodeLogins[i] = login
+			return
+		}
+	}
+	env.NodeLogins = append(env.NodeLogins, login)
 }
 
 func LoadConfig(log *logger.Logger) (*Config, error) {
+	config, configPath, err := readConfig(log, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before saving, which moves the passwords to the credential
+	// store but backs up the file still holding them
+	plaintext := config.hasPlaintextPasswords()
+	if config.migrated || plaintext {
+		if config.migrated {
+			log.Info("Saving config migrated to version %d", CurrentVersion)
+		}
+		if plaintext {
+			log.Info("Migrating plaintext passwords to the credential store")
+		}
+		if err := SaveConfig(config, log); err != nil {
+			return nil, fmt.Errorf("failed to save migrated config: %v", err)
+		}
+	}
+	if plaintext {
+		if err := config.scrubBackups(configPath, log); err != nil {
+			log.Error("Failed to remove plaintext passwords from backups: %v", err)
+		}
+	}
+
+	log.Info("Config loaded successfully")
+	return config, nil
+}
+
+// ReadConfig parses the config files without unlocking or migrating secrets,
+// or pulling the team config, for read-only callers such as shell completion
+// that must never prompt.
+func ReadConfig(log *logger.Logger) (*Config, error) {
+	config, _, err := readConfig(log, false)
+	return config, err
+}
+
+// readConfig reads the personal config file and lays it over the system and
+// team configs. A missing personal file reads as an empty one.
+func readConfig(log *logger.Logger, fetch bool) (*Config, string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		log.Error("Failed to get user home directory: %v", err)
-		return nil, err
+		return nil, "", err
 	}
 
 	configPath := filepath.Join(home, ".devctl", "config.yaml")
 	log.Info("Loading config from: %s", configPath)
 
 	data, err := ioutil.ReadFile(configPath)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		log.Error("Failed to read config file: %v", err)
-		return nil, err
+		return nil, "", err
 	}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
+	config, version, err := decodeConfig(data)
 	if err != nil {
 		log.Error("Failed to unmarshal config data: %v", err)
-		return nil, err
+		return nil, "", fmt.Errorf("failed to parse %s: %v", configPath, err)
 	}
-
-	log.Info("Config loaded successfully")
-	return &config, nil
+	if version < CurrentVersion && len(data) > 0 {
+		log.Info("Migrated config from version %d to %d", version, CurrentVersion)
+		config.migrated = true
+	}
+	config.loaded = data
+	config.loadLayers(log, fetch)
+	return config, configPath, nil
 }
 
+// SaveConfig writes config under an advisory lock shared by every devctl
+// instance. When another instance changed the file since config was loaded,
+// its changes are merged in first; see mergeChanges.
 func SaveConfig(config *Config, log *logger.Logger) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -63,29 +216,62 @@ func SaveConfig(config *Config, log *logger.Logger) error {
 	configPath := filepath.Join(home, ".devctl", "config.yaml")
 	log.Info("Saving config to: %s", configPath)
 
+	unlock, err := lockConfig(configPath)
+	if err != nil {
+		log.Error("Failed to lock config: %v", err)
+		return err
+	}
+	defer unlock()
+
+	current, err := ioutil.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("Failed to read config file: %v", err)
+		return err
+	}
+	if !bytes.Equal(current, config.loaded) {
+		log.Info("Config file changed since it was loaded, merging changes")
+		personal := config.personalLayer()
+		if err := personal.mergeChanges(current); err != nil {
+			log.Error("Failed to merge config changes: %v", err)
+			return fmt.Errorf("failed to merge changes made by another devctl: %v", err)
+		}
+		*config = *config.Compose(personal)
+	}
+
 	// Backup the existing config file before saving
 	if err := BackupConfig(configPath, log); err != nil {
 		log.Error("Failed to backup config: %v", err)
 		return fmt.Errorf("failed to backup config: %v", err)
 	}
 
-	data, err := yaml.Marshal(config)
-	if err != nil {
-		log.Error("Failed to marshal config data: %v", err)
+	if err := config.storePasswords(); err != nil {
+		log.Error("Failed to store passwords: %v", err)
 		return err
 	}
 
-	err = os.MkdirAll(filepath.Dir(configPath), 0755)
+	// Only the personal layer is written, the others are shared
+	config.Version = CurrentVersion
+	data, err := config.personalLayer().marshal()
 	if err != nil {
-		log.Error("Failed to create config directory: %v", err)
+		log.Error("Failed to marshal config data: %v", err)
 		return err
 	}
 
-	err = ioutil.WriteFile(configPath, data, 0644)
+	// Written through a temporary file, which also tightens the permissions
+	// of config files created by older versions
+	err = writeFileAtomic(configPath, data, 0600)
 	if err != nil {
 		log.Error("Failed to write config file: %v", err)
 		return err
 	}
+	config.loaded = data
+	config.migrated = false
+
+	if config.Backups != nil {
+		if err := config.pruneBackups(configPath, *config.Backups, log); err != nil {
+			log.Warning("Failed to prune config backups: %v", err)
+		}
+	}
 
 	log.Info("Config saved successfully")
 	return nil
@@ -112,11 +298,32 @@ func BackupConfig(configPath string, log *logger.Logger) error {
 		return err
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
-	backupPath := filepath.Join(backupDir, fmt.Sprintf("config_%s.yaml", timestamp))
+	// Backups taken within the same second are numbered from 2, created
+	// exclusively so that concurrent saves never overwrite one another
+	timestamp := time.Now().Format(backupTimeLayout)
+	var backupPath string
+	var file *os.File
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("config_%s.yaml", timestamp)
+		if n > 1 {
+			name = fmt.Sprintf("config_%s_%d.yaml", timestamp, n)
+		}
+		backupPath = filepath.Join(backupDir, name)
+		file, err = os.OpenFile(backupPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Error("Failed to create backup file: %v", err)
+		return err
+	}
 
 	// Write the backup file
-	err = ioutil.WriteFile(backupPath, data, 0644)
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		log.Error("Failed to write backup file: %v", err)
 		return err
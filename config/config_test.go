@@ -0,0 +1,141 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jd/devctl/credential"
+	"github.com/jd/devctl/logger"
+)
+
+// setupHome points the home directory at a temporary one holding an empty
+// ~/.devctl, and returns the path of that directory.
+func setupHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv(TeamConfigEnv, "")
+	t.Setenv(credential.PassphraseEnv, "test passphrase")
+
+	dir := filepath.Join(home, ".devctl")
+	if err := os.MkdirAll(filepath.Join(dir, "backups"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func newTestLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	log, err := logger.NewLogger(logger.DEBUG, filepath.Join(t.TempDir(), "devctl.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(log.Close)
+	return log
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const legacyConfig = `envs:
+- id: dev
+  name: Dev
+  createTime: "2024-01-02 15:04:05"
+  updateTime: "2024-01-02 15:04:05"
+  ip: 10.0.0.1
+  user: root
+  password: s3cret
+  kubeconfig: /tmp/dev
+`
+
+func TestLoadConfigScrubsLegacyPlaintext(t *testing.T) {
+	dir := setupHome(t)
+	writeFile(t, filepath.Join(dir, "config.yaml"), legacyConfig)
+	writeFile(t, filepath.Join(dir, "backups", "config_20240101_000000.yaml"), legacyConfig)
+
+	config, err := LoadConfig(newTestLogger(t))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.Version != CurrentVersion {
+		t.Errorf("version = %d, want %d", config.Version, CurrentVersion)
+	}
+
+	store, err := config.CredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := store.Get(config.Envs[0].Password); err != nil || password != "s3cret" {
+		t.Errorf("password = %q, %v, want s3cret", password, err)
+	}
+
+	backups, err := filepath.Glob(filepath.Join(dir, "backups", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("found %d backups, want the existing one and the one taken by the migration", len(backups))
+	}
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), "s3cret") {
+			t.Errorf("%s still holds the plaintext password", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScrubBackupsKeepsCurrentSecrets(t *testing.T) {
+	dir := setupHome(t)
+	config := func(password string) string {
+		return "version: 1\ncredentials: {backend: file}\nenvs:\n- {id: dev, ip: 10.0.0.1, user: root, password: " + password + "}\n"
+	}
+	writeFile(t, filepath.Join(dir, "config.yaml"), config("current"))
+	// Scrubbing older backups must not overwrite the current password
+	writeFile(t, filepath.Join(dir, "backups", "config_20240101_000000.yaml"), config("older"))
+	writeFile(t, filepath.Join(dir, "backups", "config_20240102_000000.yaml"), config("old"))
+
+	c, err := LoadConfig(newTestLogger(t))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	store, err := c.CredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := store.Get(c.Envs[0].Password); err != nil || password != "current" {
+		t.Errorf("current password = %q, %v, want current", password, err)
+	}
+
+	for name, want := range map[string]string{
+		"config_20240101_000000.yaml": "older",
+		"config_20240102_000000.yaml": "old",
+	} {
+		backup, err := Backup{Name: name, Path: filepath.Join(dir, "backups", name)}.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := backup.Envs[0].Password
+		if password, err := store.Get(ref); err != nil || password != want {
+			t.Errorf("password of %s = %q (%s), %v, want %s", name, password, ref, err, want)
+		}
+	}
+}
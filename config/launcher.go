@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Launcher is a command the cluster list runs against the selected cluster.
+// Command is a text/template run by the shell, in which {{.Kubeconfig}},
+// {{.EnvID}}, {{.ClusterID}} and {{.ApiServer}} expand shell-quoted. Key is a
+// single character or "enter".
+type Launcher struct {
+	Name    string   `yaml:"name"`
+	Key     string   `yaml:"key,omitempty"`
+	Command string   `yaml:"command"`
+	Env     []string `yaml:"env,omitempty"`
+}
+
+// DefaultLaunchers are available unless the config defines a launcher of the
+// same name.
+var DefaultLaunchers = []Launcher{
+	{
+		Name:    "k9s",
+		Key:     "enter",
+		Command: "k9s --kubeconfig {{.Kubeconfig}} --logLevel debug",
+		Env:     []string{"EDITOR=vim"},
+	},
+}
+
+// ReservedLauncherKeys are the keys of the cluster list's own actions, which
+// take precedence over launchers bound to them.
+var ReservedLauncherKeys = []string{"a", "d", "e", "q", "r", "s", "l", "u", " ", "v", "x"}
+
+// BoundTo reports whether the launcher runs on key, "enter" or the character
+// typed. Characters match exactly, so "T" is shift+t and not "t".
+func (l Launcher) BoundTo(key string) bool {
+	if strings.EqualFold(l.Key, "enter") {
+		return key == "enter"
+	}
+	return l.Key == key
+}
+
+// GetLaunchers returns the default launchers followed by the configured ones,
+// those of the personal config overriding the system and team ones by name.
+func (c *Config) GetLaunchers() []Launcher {
+	return overlayLaunchers(DefaultLaunchers, overlayLaunchers(c.lowerLaunchers, c.Launchers))
+}
+
+// GetLauncher returns the launcher called name.
+func (c *Config) GetLauncher(name string) (Launcher, bool) {
+	for _, launcher := range c.GetLaunchers() {
+		if launcher.Name == name {
+			return launcher, true
+		}
+	}
+	return Launcher{}, false
+}
+
+// validateLaunchers reports launchers whose key can never run them, being
+// malformed, one of ReservedLauncherKeys or bound to an earlier launcher.
+func (c *Config) validateLaunchers() []Problem {
+	var problems []Problem
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, Problem{Field: "launchers", Message: fmt.Sprintf(format, args...)})
+	}
+
+	bound := make(map[string]string)
+	for _, launcher := range c.GetLaunchers() {
+		key := launcher.Key
+		if strings.EqualFold(key, "enter") {
+			key = "enter"
+		}
+		switch {
+		case key == "":
+			continue
+		case utf8.RuneCountInString(key) != 1 && key != "enter":
+			add("launcher %q key %q must be a single character or \"enter\"", launcher.Name, launcher.Key)
+		case contains(ReservedLauncherKeys, key):
+			add("launcher %q key %q is already used by the cluster list, bind it to another key", launcher.Name, launcher.Key)
+		case bound[key] != "":
+			add("launcher %q key %q is already bound to launcher %q", launcher.Name, launcher.Key, bound[key])
+		default:
+			bound[key] = launcher.Name
+		}
+	}
+	return problems
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLaunchers(t *testing.T) {
+	tests := []struct {
+		name      string
+		launchers []Launcher
+		want      string
+	}{
+		{name: "free key", launchers: []Launcher{{Name: "stern", Key: "t"}}},
+		{name: "no key", launchers: []Launcher{{Name: "stern"}}},
+		{name: "built-in key", launchers: []Launcher{{Name: "stern", Key: "s"}}, want: `launcher "stern" key "s" is already used`},
+		{name: "space", launchers: []Launcher{{Name: "stern", Key: " "}}, want: "already used"},
+		{name: "default launcher key", launchers: []Launcher{{Name: "stern", Key: "Enter"}}, want: `already bound to launcher "k9s"`},
+		{name: "overridden default launcher", launchers: []Launcher{{Name: "k9s", Key: "k"}, {Name: "stern", Key: "enter"}}},
+		{name: "same key", launchers: []Launcher{{Name: "stern", Key: "t"}, {Name: "top", Key: "t"}}, want: `launcher "top" key "t" is already bound to launcher "stern"`},
+		{name: "shifted key", launchers: []Launcher{{Name: "stern", Key: "t"}, {Name: "top", Key: "T"}}},
+		{name: "shifted built-in key", launchers: []Launcher{{Name: "stern", Key: "S"}}},
+		{name: "long key", launchers: []Launcher{{Name: "stern", Key: "tt"}}, want: "must be a single character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := (&Config{Launchers: tt.launchers}).validateLaunchers()
+			switch {
+			case tt.want == "" && len(problems) > 0:
+				t.Errorf("unexpected problems %v", problems)
+			case tt.want != "" && (len(problems) != 1 || !strings.Contains(problems[0].String(), tt.want)):
+				t.Errorf("problems = %v, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestLauncherBoundTo(t *testing.T) {
+	top := Launcher{Name: "top", Key: "T"}
+	if !top.BoundTo("T") || top.BoundTo("t") {
+		t.Errorf("launcher on %q should only run on that exact key", top.Key)
+	}
+	k9s := Launcher{Name: "k9s", Key: "Enter"}
+	if !k9s.BoundTo("enter") || k9s.BoundTo("E") {
+		t.Errorf("launcher on %q should only run on enter", k9s.Key)
+	}
+}
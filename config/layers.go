@@ -0,0 +1,395 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jd/devctl/logger"
+	"gopkg.in/yaml.v2"
+)
+
+// Layers an environment can come from, lowest precedence first. SaveConfig
+// only ever writes the personal layer, ~/.devctl/config.yaml.
+const (
+	LayerSystem   = "system"
+	LayerTeam     = "team"
+	LayerPersonal = "personal"
+)
+
+// TeamConfigEnv names the team config shared through version control, either
+// a file path or a git URL optionally followed by #<path in the repository>,
+// config.yaml by default.
+const TeamConfigEnv = "DEVCTL_TEAM_CONFIG"
+
+// teamRefreshInterval bounds how often a team config repository is pulled.
+const teamRefreshInterval = 10 * time.Minute
+
+// SystemConfigPath returns the path of the system-wide config.
+func SystemConfigPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "devctl", "config.yaml")
+	}
+	return "/etc/devctl/config.yaml"
+}
+
+type layerSource struct {
+	layer string
+	path  string
+	err   error
+}
+
+// loadLayers reads the system and team configs under c, which holds the
+// personal layer. fetch allows pulling a team repository. Layers that fail to
+// load are skipped and reported by Validate.
+func (c *Config) loadLayers(log *logger.Logger, fetch bool) {
+	c.lower = nil
+	c.lowerLaunchers = nil
+	c.layerErrors = nil
+
+	sources := []layerSource{{layer: LayerSystem, path: SystemConfigPath()}}
+	if team := os.Getenv(TeamConfigEnv); team != "" {
+		path, err := teamConfigPath(team, log, fetch)
+		sources = append(sources, layerSource{layer: LayerTeam, path: path, err: err})
+	}
+
+	for _, source := range sources {
+		if source.err != nil {
+			c.layerErrors = append(c.layerErrors, fmt.Errorf("%s config: %v", source.layer, source.err))
+			continue
+		}
+		data, err := ioutil.ReadFile(source.path)
+		if os.IsNotExist(err) && source.layer == LayerSystem {
+			continue
+		}
+		if err != nil {
+			c.layerErrors = append(c.layerErrors, fmt.Errorf("%s config: %v", source.layer, err))
+			continue
+		}
+		layer, _, err := decodeConfig(data)
+		if err != nil {
+			c.layerErrors = append(c.layerErrors, fmt.Errorf("%s config %s: %v", source.layer, source.path, err))
+			continue
+		}
+
+		log.Info("Loaded %s config from %s", source.layer, source.path)
+		for _, env := range layer.Envs {
+			env.Layer = source.layer
+			c.lower = overlayEnvs(c.lower, env, layer.keys[env.ID])
+		}
+		c.lowerLaunchers = overlayLaunchers(c.lowerLaunchers, layer.Launchers)
+	}
+
+	for i := range c.lower {
+		c.lower[i].Kubeconfig = kubeconfigPath(c.lower[i])
+	}
+	c.Envs = composeEnvs(c.lower, c.Envs, c.keys)
+}
+
+// composeEnvs lays the personal environments over the lower layers. A
+// personal entry for a lower environment only overrides the keys it sets,
+// see overlayEnv.
+func composeEnvs(lower, personal []Environment, keys map[string][]string) []Environment {
+	envs := append([]Environment{}, lower...)
+	for _, env := range personal {
+		env.Layer = LayerPersonal
+		// Duplicates within the personal file are left for Validate to report
+		if _, ok := findEnv(lower, env.ID); ok {
+			envs = overlayEnvs(envs, env, keys[env.ID])
+		} else {
+			envs = append(envs, env)
+		}
+	}
+	return envs
+}
+
+func overlayEnvs(envs []Environment, env Environment, keys []string) []Environment {
+	for i := range envs {
+		if envs[i].ID == env.ID {
+			layer := envs[i].Layer
+			envs[i] = overlayEnv(envs[i], env, keys)
+			envs[i].Layer = layer + "+" + env.Layer
+			return envs
+		}
+	}
+	return append(envs, env)
+}
+
+// overlayEnv returns base with the fields of overlay named by keys, which
+// may clear them, or with its non-empty fields when keys is nil, as for
+// environments that were not read from a file.
+func overlayEnv(base, overlay Environment, keys []string) Environment {
+	result := reflect.ValueOf(&base).Elem()
+	value := reflect.ValueOf(overlay)
+	for i := 0; i < value.NumField(); i++ {
+		key, _ := yamlKey(value.Type().Field(i))
+		if key == "-" {
+			continue
+		}
+		if contains(keys, key) || (keys == nil && !isEmpty(value.Field(i))) {
+			result.Field(i).Set(value.Field(i))
+		}
+	}
+	return base
+}
+
+// personalLayer returns a copy of c holding only what the personal file
+// stores: its own environments, and for environments of lower layers the
+// keys whose value differs from them.
+func (c *Config) personalLayer() *Config {
+	personal := *c
+	personal.Envs = nil
+	personal.keys = make(map[string][]string)
+	for _, env := range c.Envs {
+		env.Layer = ""
+		lower, ok := findEnv(c.lower, env.ID)
+		if !ok {
+			personal.Envs = append(personal.Envs, env)
+			continue
+		}
+
+		var keys []string
+		a, b := reflect.ValueOf(lower), reflect.ValueOf(env)
+		for i := 0; i < b.NumField(); i++ {
+			key, _ := yamlKey(b.Type().Field(i))
+			if key != "-" && !sameYAML(a.Field(i).Interface(), b.Field(i).Interface()) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) > 0 {
+			personal.Envs = append(personal.Envs, env)
+			personal.keys[env.ID] = keys
+		}
+	}
+	return &personal
+}
+
+// Compose returns personal, such as a parsed backup, laid over the lower
+// layers c was loaded with.
+func (c *Config) Compose(personal *Config) *Config {
+	composed := *personal
+	composed.lower = c.lower
+	composed.lowerLaunchers = c.lowerLaunchers
+	composed.layerErrors = c.layerErrors
+	composed.Envs = composeEnvs(c.lower, personal.Envs, personal.keys)
+	return &composed
+}
+
+// marshal encodes c as a personal file, see envEntries.
+func (c *Config) marshal() ([]byte, error) {
+	doc := fieldMap(reflect.ValueOf(*c), nil)
+	return yaml.Marshal(set(doc, "envs", c.envEntries(c.lower)))
+}
+
+// envEntries returns the environments of c as written to its file. Those
+// overriding an environment of lower only hold the keys they override,
+// written even when empty so that clearing a field overrides it too.
+func (c *Config) envEntries(lower []Environment) []yaml.MapSlice {
+	entries := []yaml.MapSlice{}
+	for _, env := range c.Envs {
+		if _, ok := findEnv(lower, env.ID); !ok {
+			entries = append(entries, fieldMap(reflect.ValueOf(env), nil))
+			continue
+		}
+		keys, ok := c.keys[env.ID]
+		if !ok {
+			keys = []string{}
+			value := reflect.ValueOf(env)
+			for i := 0; i < value.NumField(); i++ {
+				if key, _ := yamlKey(value.Type().Field(i)); key != "-" && !isEmpty(value.Field(i)) {
+					keys = append(keys, key)
+				}
+			}
+		}
+		entries = append(entries, fieldMap(reflect.ValueOf(env), keys))
+	}
+	return entries
+}
+
+// setEnvEntries replaces the environments of c with entries as returned by
+// envEntries.
+func (c *Config) setEnvEntries(entries []yaml.MapSlice) error {
+	var envs []Environment
+	keys := make(map[string][]string)
+	for _, entry := range entries {
+		data, err := yaml.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		var env Environment
+		if err := yaml.UnmarshalStrict(data, &env); err != nil {
+			return err
+		}
+		for _, item := range entry {
+			keys[env.ID] = append(keys[env.ID], fmt.Sprint(item.Key))
+		}
+		envs = append(envs, env)
+	}
+	c.Envs, c.keys = envs, keys
+	return nil
+}
+
+// fieldMap returns the exported fields of the struct v under their YAML
+// keys. When keys is not nil only those are returned, even when empty;
+// otherwise empty omitempty fields are left out.
+func fieldMap(v reflect.Value, keys []string) yaml.MapSlice {
+	var fields yaml.MapSlice
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key, omitEmpty := yamlKey(field)
+		switch {
+		case field.PkgPath != "" || key == "-":
+			continue
+		case keys != nil:
+			if key != "id" && !contains(keys, key) {
+				continue
+			}
+		case omitEmpty && isEmpty(v.Field(i)):
+			continue
+		}
+		fields = append(fields, yaml.MapItem{Key: key, Value: v.Field(i).Interface()})
+	}
+	return fields
+}
+
+func yamlKey(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("yaml"), ",")
+	return tag[0], len(tag) > 1 && tag[1] == "omitempty"
+}
+
+// isEmpty reports whether yaml considers v empty for omitempty.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// LowerLayer returns the layer env was defined in when it does not come from
+// the personal file, which can then neither delete it nor change its ID.
+func (c *Config) LowerLayer(id string) (string, bool) {
+	env, ok := findEnv(c.lower, id)
+	return env.Layer, ok
+}
+
+func overlayLaunchers(launchers, overlay []Launcher) []Launcher {
+	result := append([]Launcher{}, launchers...)
+	for _, launcher := range overlay {
+		replaced := false
+		for i := range result {
+			if result[i].Name == launcher.Name {
+				result[i] = launcher
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, launcher)
+		}
+	}
+	return result
+}
+
+// kubeconfigPath expands ~ in the kubeconfig of a shared environment, which
+// defaults to where AddEnvironment would download it.
+func kubeconfigPath(env Environment) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return env.Kubeconfig
+	}
+	switch {
+	case env.Kubeconfig == "":
+		return filepath.Join(home, ".devctl", "kubeconfigs", env.ID, "config")
+	case strings.HasPrefix(env.Kubeconfig, "~/"):
+		return filepath.Join(home, env.Kubeconfig[2:])
+	default:
+		return env.Kubeconfig
+	}
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// teamConfigPath resolves the team config source to a local file, cloning or
+// pulling it first when it is a git URL. A failed pull falls back to the
+// existing checkout.
+func teamConfigPath(source string, log *logger.Logger, fetch bool) (string, error) {
+	url, file, _ := strings.Cut(source, "#")
+	if !isGitURL(url) {
+		return source, nil
+	}
+	if file == "" {
+		file = "config.yaml"
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".devctl", "team", unsafePathChars.ReplaceAllString(url, "_"))
+	path := filepath.Join(dir, filepath.FromSlash(file))
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		if !fetch {
+			return "", fmt.Errorf("%s is not checked out yet", url)
+		}
+		log.Info("Cloning team config from %s", url)
+		if err := runGit("", "clone", "--depth", "1", "--quiet", url, dir); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	if fetch && stale(filepath.Join(dir, ".git", "FETCH_HEAD")) {
+		log.Info("Pulling team config from %s", url)
+		if err := runGit(dir, "pull", "--ff-only", "--quiet"); err != nil {
+			log.Warning("Failed to pull team config, using the existing checkout: %v", err)
+		}
+	}
+	return path, nil
+}
+
+func isGitURL(source string) bool {
+	for _, prefix := range []string{"git@", "ssh://", "git://", "http://", "https://"} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return strings.HasSuffix(source, ".git")
+}
+
+func stale(path string) bool {
+	info, err := os.Stat(path)
+	return err != nil || time.Since(info.ModTime()) > teamRefreshInterval
+}
+
+func runGit(dir string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Never prompt for credentials, devctl may be running its UI
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var teamEnv = Environment{
+	Layer:           LayerTeam,
+	ID:              "shared",
+	Name:            "Shared",
+	IP:              "10.1.1.1",
+	User:            "ops",
+	Kubeconfig:      "/tmp/shared",
+	TunnelAPIServer: true,
+	Proxy:           "socks5://proxy:1080",
+}
+
+func TestPersonalLayer(t *testing.T) {
+	mine := Environment{ID: "mine", Name: "Mine", IP: "10.0.0.1", User: "root", Kubeconfig: "/tmp/mine"}
+	renamed := teamEnv
+	renamed.Name = "Renamed"
+	cleared := teamEnv
+	cleared.TunnelAPIServer = false
+	cleared.Proxy = ""
+
+	tests := []struct {
+		name     string
+		envs     []Environment
+		wantEnvs []string
+		wantKeys map[string][]string
+	}{
+		{
+			name:     "unchanged shared environment is not written",
+			envs:     []Environment{teamEnv, mine},
+			wantEnvs: []string{"mine"},
+			wantKeys: map[string][]string{},
+		},
+		{
+			name:     "changed field is overridden",
+			envs:     []Environment{renamed},
+			wantEnvs: []string{"shared"},
+			wantKeys: map[string][]string{"shared": {"name"}},
+		},
+		{
+			name:     "cleared fields are overridden",
+			envs:     []Environment{cleared, mine},
+			wantEnvs: []string{"shared", "mine"},
+			wantKeys: map[string][]string{"shared": {"tunnelAPIServer", "proxy"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Envs: tt.envs, lower: []Environment{teamEnv}}
+			personal := c.personalLayer()
+
+			var ids []string
+			for _, env := range personal.Envs {
+				ids = append(ids, env.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantEnvs) {
+				t.Errorf("personal environments = %v, want %v", ids, tt.wantEnvs)
+			}
+			if !reflect.DeepEqual(personal.keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", personal.keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestComposeRoundTrip(t *testing.T) {
+	mine := Environment{Layer: LayerPersonal, ID: "mine", Name: "Mine", IP: "10.0.0.1", User: "root", Kubeconfig: "/tmp/mine"}
+	renamed := teamEnv
+	renamed.Name = "Renamed"
+	cleared := teamEnv
+	cleared.TunnelAPIServer = false
+	cleared.Proxy = ""
+
+	tests := []struct {
+		name string
+		envs []Environment
+	}{
+		{name: "no override", envs: []Environment{teamEnv, mine}},
+		{name: "renamed", envs: []Environment{renamed, mine}},
+		{name: "proxy and tunnel cleared", envs: []Environment{cleared}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Envs: tt.envs, lower: []Environment{teamEnv}}
+			data, err := c.personalLayer().marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			personal, _, err := decodeConfig(data)
+			if err != nil {
+				t.Fatalf("decodeConfig: %v\n%s", err, data)
+			}
+
+			composed := c.Compose(personal)
+			for i, want := range tt.envs {
+				if i >= len(composed.Envs) {
+					t.Fatalf("environment %s is missing after a round trip", want.ID)
+				}
+				got := composed.Envs[i]
+				got.Layer, want.Layer = "", ""
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("environment after a round trip = %+v, want %+v\n%s", got, want, data)
+				}
+			}
+			if len(composed.Envs) != len(tt.envs) {
+				t.Errorf("got %d environments, want %d", len(composed.Envs), len(tt.envs))
+			}
+		})
+	}
+}
+
+func TestComposeEnvs(t *testing.T) {
+	tests := []struct {
+		name      string
+		personal  Environment
+		keys      map[string][]string
+		want      Environment
+		wantLayer string
+	}{
+		{
+			name:      "listed keys override, even when empty",
+			personal:  Environment{ID: "shared", User: "me"},
+			keys:      map[string][]string{"shared": {"id", "user", "proxy", "tunnelAPIServer"}},
+			want:      Environment{ID: "shared", Name: "Shared", IP: "10.1.1.1", User: "me", Kubeconfig: "/tmp/shared"},
+			wantLayer: "team+personal",
+		},
+		{
+			name:     "without keys only set fields override",
+			personal: Environment{ID: "shared", User: "me"},
+			want: Environment{ID: "shared", Name: "Shared", IP: "10.1.1.1", User: "me", Kubeconfig: "/tmp/shared",
+				TunnelAPIServer: true, Proxy: "socks5://proxy:1080"},
+			wantLayer: "team+personal",
+		},
+		{
+			name:      "personal environment is appended",
+			personal:  Environment{ID: "mine", User: "me"},
+			want:      Environment{ID: "mine", User: "me"},
+			wantLayer: LayerPersonal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envs := composeEnvs([]Environment{teamEnv}, []Environment{tt.personal}, tt.keys)
+			got, ok := findEnv(envs, tt.want.ID)
+			if !ok {
+				t.Fatalf("environment %s is missing", tt.want.ID)
+			}
+			if got.Layer != tt.wantLayer {
+				t.Errorf("layer = %q, want %q", got.Layer, tt.wantLayer)
+			}
+			got.Layer = ""
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	team := `envs:
+- {id: shared, name: Shared, ip: 10.1.1.1, user: ops, proxy: 'socks5://proxy:1080'}
+launchers:
+- {name: stern, key: t, command: stern}
+`
+
+	tests := []struct {
+		name      string
+		team      string
+		personal  string
+		want      map[string]Environment
+		wantStern string
+		wantErr   string
+	}{
+		{
+			name:      "team environment",
+			team:      team,
+			personal:  "version: 1\nenvs: []\n",
+			want:      map[string]Environment{"shared": {Layer: LayerTeam, Name: "Shared", IP: "10.1.1.1", Proxy: "socks5://proxy:1080"}},
+			wantStern: "stern",
+		},
+		{
+			name:      "personal overrides",
+			team:      team,
+			personal:  "version: 1\nenvs:\n- {id: shared, ip: 10.2.2.2, proxy: ''}\n- {id: mine, name: Mine, ip: 10.0.0.1, user: root}\nlaunchers:\n- {name: stern, key: t, command: stern --tail 10}\n",
+			want:      map[string]Environment{"shared": {Layer: LayerTeam + "+" + LayerPersonal, Name: "Shared", IP: "10.2.2.2"}, "mine": {Layer: LayerPersonal, Name: "Mine", IP: "10.0.0.1"}},
+			wantStern: "stern --tail 10",
+		},
+		{
+			name:     "unreadable team config",
+			team:     "envs: [",
+			personal: "version: 1\nenvs: []\n",
+			want:     map[string]Environment{},
+			wantErr:  "team config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupHome(t)
+			teamPath := filepath.Join(t.TempDir(), "team.yaml")
+			writeFile(t, teamPath, tt.team)
+			t.Setenv(TeamConfigEnv, teamPath)
+			writeFile(t, filepath.Join(dir, "config.yaml"), tt.personal)
+
+			c, err := ReadConfig(newTestLogger(t))
+			if err != nil {
+				t.Fatalf("ReadConfig: %v", err)
+			}
+			if len(c.Envs) != len(tt.want) {
+				t.Errorf("got %d environments, want %d", len(c.Envs), len(tt.want))
+			}
+			for _, env := range c.Envs {
+				want := tt.want[env.ID]
+				got := Environment{Layer: env.Layer, Name: env.Name, IP: env.IP, Proxy: env.Proxy}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("environment %s = %+v, want %+v", env.ID, got, want)
+				}
+			}
+
+			stern, _ := c.GetLauncher("stern")
+			if stern.Command != tt.wantStern {
+				t.Errorf("stern launcher command = %q, want %q", stern.Command, tt.wantStern)
+			}
+
+			problems := c.Validate()
+			if tt.wantErr != "" && (len(problems) == 0 || !strings.Contains(problems[0].String(), tt.wantErr)) {
+				t.Errorf("problems = %v, want one about %q", problems, tt.wantErr)
+			}
+		})
+	}
+}
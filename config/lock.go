@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockTimeout bounds how long a save waits for another devctl to finish
+// writing the config.
+const lockTimeout = 10 * time.Second
+
+// lockConfig takes an advisory lock on configPath, held by every devctl
+// instance while it reads and rewrites the file. The lock is released by
+// calling unlock, or when the process exits.
+func lockConfig(configPath string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(configPath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config lock: %v", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if err != errLocked || time.Now().After(deadline) {
+			f.Close()
+			if err == errLocked {
+				return nil, fmt.Errorf("config is locked by another devctl, lock file %s", f.Name())
+			}
+			return nil, fmt.Errorf("failed to lock config: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the same
+// directory, so a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// mergeChanges rebases the changes made to c since it was loaded onto data,
+// the config file as another devctl instance rewrote it in the meantime.
+// Environments are merged one by one; an environment, or any other setting,
+// changed differently on both sides is a conflict and nothing is merged.
+func (c *Config) mergeChanges(data []byte) error {
+	base, theirs := &Config{}, &Config{}
+	var err error
+	if len(c.loaded) > 0 {
+		if base, _, err = decodeConfig(c.loaded); err != nil {
+			return fmt.Errorf("failed to parse loaded config: %v", err)
+		}
+	}
+	if len(data) > 0 {
+		if theirs, _, err = decodeConfig(data); err != nil {
+			return fmt.Errorf("config file was changed and can no longer be parsed, refusing to overwrite it: %v", err)
+		}
+	}
+
+	// Compared as written, so that clearing an override is a change
+	envs, err := mergeEnvs(base.envEntries(c.lower), c.envEntries(c.lower), theirs.envEntries(c.lower))
+	if err != nil {
+		return err
+	}
+	if err := c.setEnvEntries(envs); err != nil {
+		return err
+	}
+
+	secrets, err := mergeSetting("secrets", base.Secrets, c.Secrets, theirs.Secrets)
+	if err != nil {
+		return err
+	}
+	credentials, err := mergeSetting("credentials", base.Credentials, c.Credentials, theirs.Credentials)
+	if err != nil {
+		return err
+	}
+	launchers, err := mergeSetting("launchers", base.Launchers, c.Launchers, theirs.Launchers)
+	if err != nil {
+		return err
+	}
+	backups, err := mergeSetting("backups", base.Backups, c.Backups, theirs.Backups)
+	if err != nil {
+		return err
+	}
+
+	c.Secrets = secrets.(*Secrets)
+	c.Credentials = credentials.(*Credentials)
+	c.Launchers = launchers.([]Launcher)
+	c.Backups = backups.(*BackupPolicy)
+	c.loaded = data
+	return nil
+}
+
+// mergeEnvs applies the environment entries added, changed and removed in
+// ours since base to theirs, keeping the order of theirs.
+func mergeEnvs(base, ours, theirs []yaml.MapSlice) ([]yaml.MapSlice, error) {
+	merged := append([]yaml.MapSlice{}, theirs...)
+	index := func(envs []yaml.MapSlice, id interface{}) int {
+		for i, env := range envs {
+			if valueOf(env, "id") == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, env := range ours {
+		id := valueOf(env, "id")
+		b, t := index(base, id), index(merged, id)
+		switch {
+		case b >= 0 && sameYAML(base[b], env):
+			// Unchanged here, whatever the other side did wins
+		case t < 0 && b >= 0:
+			return nil, fmt.Errorf("environment %v was changed here but deleted by another devctl", id)
+		case t < 0:
+			merged = append(merged, env)
+		case sameYAML(merged[t], env):
+		case b < 0:
+			return nil, fmt.Errorf("environment %v was added both here and by another devctl", id)
+		case !sameYAML(base[b], merged[t]):
+			return nil, fmt.Errorf("environment %v was changed both here and by another devctl", id)
+		default:
+			merged[t] = env
+		}
+	}
+
+	for _, env := range base {
+		id := valueOf(env, "id")
+		if index(ours, id) >= 0 {
+			continue
+		}
+		t := index(merged, id)
+		if t < 0 {
+			continue
+		}
+		if !sameYAML(env, merged[t]) {
+			return nil, fmt.Errorf("environment %v was deleted here but changed by another devctl", id)
+		}
+		merged = append(merged[:t], merged[t+1:]...)
+	}
+	return merged, nil
+}
+
+// mergeSetting returns ours when it changed since base and theirs otherwise.
+func mergeSetting(name string, base, ours, theirs interface{}) (interface{}, error) {
+	switch {
+	case sameYAML(base, ours):
+		return theirs, nil
+	case sameYAML(base, theirs) || sameYAML(ours, theirs):
+		return ours, nil
+	default:
+		return nil, fmt.Errorf("%s were changed both here and by another devctl", name)
+	}
+}
+
+// sameYAML compares values as they are written to the config file, where
+// nil and empty lists are the same.
+func sameYAML(a, b interface{}) bool {
+	x, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func entry(id, ip string) yaml.MapSlice {
+	return yaml.MapSlice{{Key: "id", Value: id}, {Key: "ip", Value: ip}}
+}
+
+func entries(envs ...yaml.MapSlice) []yaml.MapSlice {
+	return envs
+}
+
+func TestMergeEnvs(t *testing.T) {
+	a, b := entry("a", "10.0.0.1"), entry("b", "10.0.0.2")
+	a2, a3 := entry("a", "10.0.1.1"), entry("a", "10.0.2.1")
+
+	tests := []struct {
+		name    string
+		base    []yaml.MapSlice
+		ours    []yaml.MapSlice
+		theirs  []yaml.MapSlice
+		want    []yaml.MapSlice
+		wantErr string
+	}{
+		{name: "unchanged", base: entries(a), ours: entries(a), theirs: entries(a), want: entries(a)},
+		{name: "changed here", base: entries(a), ours: entries(a2), theirs: entries(a), want: entries(a2)},
+		{name: "changed there", base: entries(a), ours: entries(a), theirs: entries(a2), want: entries(a2)},
+		{name: "changed alike", base: entries(a), ours: entries(a2), theirs: entries(a2), want: entries(a2)},
+		{name: "changed both", base: entries(a), ours: entries(a2), theirs: entries(a3), wantErr: "changed both here and by another devctl"},
+		{name: "added here", ours: entries(a), theirs: entries(b), want: entries(b, a)},
+		{name: "added alike", ours: entries(a), theirs: entries(a), want: entries(a)},
+		{name: "added both", ours: entries(a), theirs: entries(a2), wantErr: "added both here and by another devctl"},
+		{name: "deleted here", base: entries(a, b), ours: entries(b), theirs: entries(a, b), want: entries(b)},
+		{name: "deleted there", base: entries(a, b), ours: entries(a, b), theirs: entries(b), want: entries(b)},
+		{name: "deleted both", base: entries(a, b), ours: entries(b), theirs: entries(b), want: entries(b)},
+		{name: "deleted here, changed there", base: entries(a), theirs: entries(a2), wantErr: "deleted here but changed"},
+		{name: "changed here, deleted there", base: entries(a), ours: entries(a2), wantErr: "changed here but deleted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeEnvs(tt.base, tt.ours, tt.theirs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mergeEnvs: %v", err)
+			}
+			if !sameYAML(merged, tt.want) {
+				t.Errorf("merged = %v, want %v", merged, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSetting(t *testing.T) {
+	one, two, three := &BackupPolicy{Keep: 1}, &BackupPolicy{Keep: 2}, &BackupPolicy{Keep: 3}
+
+	tests := []struct {
+		name    string
+		base    *BackupPolicy
+		ours    *BackupPolicy
+		theirs  *BackupPolicy
+		want    *BackupPolicy
+		wantErr bool
+	}{
+		{name: "unchanged", base: one, ours: one, theirs: one, want: one},
+		{name: "changed here", base: one, ours: two, theirs: one, want: two},
+		{name: "changed there", base: one, ours: one, theirs: two, want: two},
+		{name: "set here", ours: two, want: two},
+		{name: "cleared there", base: one, ours: one, want: nil},
+		{name: "changed alike", base: one, ours: two, theirs: two, want: two},
+		{name: "changed both", base: one, ours: two, theirs: three, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeSetting("backups", tt.base, tt.ours, tt.theirs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && merged.(*BackupPolicy) != tt.want {
+				t.Errorf("merged = %v, want %v", merged, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveConfigMergesConcurrentChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   func(*Config)
+		second  func(*Config)
+		wantIPs map[string]string
+		wantErr string
+	}{
+		{
+			name:    "different environments",
+			first:   func(c *Config) { c.Envs[0].IP = "10.0.1.1" },
+			second:  func(c *Config) { c.Envs = append(c.Envs, Environment{ID: "prod", IP: "10.1.0.1", User: "root"}) },
+			wantIPs: map[string]string{"dev": "10.0.1.1", "prod": "10.1.0.1"},
+		},
+		{
+			name:    "same environment",
+			first:   func(c *Config) { c.Envs[0].IP = "10.0.1.1" },
+			second:  func(c *Config) { c.Envs[0].IP = "10.0.2.1" },
+			wantErr: "environment dev was changed both here and by another devctl",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupHome(t)
+			writeFile(t, filepath.Join(dir, "config.yaml"), "version: 1\nenvs:\n- {id: dev, ip: 10.0.0.1, user: root}\n")
+			log := newTestLogger(t)
+
+			// Two devctl instances load the same file and save in turn
+			first, err := LoadConfig(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := LoadConfig(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.first(first)
+			if err := SaveConfig(first, log); err != nil {
+				t.Fatalf("first SaveConfig: %v", err)
+			}
+			tt.second(second)
+			err = SaveConfig(second, log)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("second SaveConfig: %v", err)
+			}
+
+			saved, err := ReadConfig(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ips := make(map[string]string)
+			for _, env := range saved.Envs {
+				ips[env.ID] = env.IP
+			}
+			if !sameYAML(ips, tt.wantIPs) {
+				t.Errorf("saved environments = %v, want %v", ips, tt.wantIPs)
+			}
+		})
+	}
+}
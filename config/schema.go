@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentVersion is the config schema version this devctl writes. Files
+// without a version predate versioning and are version 0.
+const CurrentVersion = 1
+
+// TimeLayout is how environment timestamps are shown, and how they were
+// stored before version 1.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// FormatTime formats t with TimeLayout in local time, a zero t as "".
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(TimeLayout)
+}
+
+// migrations upgrade a config document from version i to i+1.
+var migrations = []func(doc yaml.MapSlice) error{
+	migrateTimestamps,
+}
+
+// decodeConfig parses data, migrating it first when it uses an older schema.
+// Unknown fields are errors reporting their line in data. It returns the
+// version the document was migrated from, CurrentVersion when it was already
+// current.
+func decodeConfig(data []byte) (*Config, int, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+
+	version := 0
+	if value, ok := lookup(doc, "version"); ok {
+		v, ok := value.(int)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid config version %v", value)
+		}
+		version = v
+	}
+	if version > CurrentVersion {
+		return nil, 0, fmt.Errorf("config version %d is newer than this devctl supports (%d), please upgrade devctl", version, CurrentVersion)
+	}
+
+	if version < CurrentVersion {
+		// Fields are checked in the file as written, so errors point at its
+		// lines; their values are only valid once migrated
+		shape := reflect.New(looseType(reflect.TypeOf(Config{})))
+		if err := yaml.UnmarshalStrict(data, shape.Interface()); err != nil {
+			return nil, 0, err
+		}
+		for _, migrate := range migrations[version:] {
+			if err := migrate(doc); err != nil {
+				return nil, 0, fmt.Errorf("failed to migrate config from version %d: %v", version, err)
+			}
+		}
+		doc = set(doc, "version", CurrentVersion)
+
+		var err error
+		if data, err = yaml.Marshal(doc); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		if version < CurrentVersion {
+			return nil, 0, fmt.Errorf("%v (in the config migrated from version %d)", err, version)
+		}
+		return nil, 0, err
+	}
+	config.keys = envKeys(doc)
+	return &config, version, nil
+}
+
+// envKeys returns the keys set by each environment of doc, by ID.
+func envKeys(doc yaml.MapSlice) map[string][]string {
+	keys := make(map[string][]string)
+	envs, _ := lookup(doc, "envs")
+	list, _ := envs.([]interface{})
+	for _, item := range list {
+		env, ok := item.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		id := fmt.Sprint(valueOf(env, "id"))
+		for _, field := range env {
+			keys[id] = append(keys[id], fmt.Sprint(field.Key))
+		}
+	}
+	return keys
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// looseType returns t with every time.Time replaced by interface{}, the
+// fields migrations convert, so strictly decoding a document of an older
+// version into it only checks its field names. Migrations must not rename
+// fields for this to hold.
+func looseType(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == timeType {
+			return reflect.TypeOf((*interface{})(nil)).Elem()
+		}
+		var fields []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			field.Type = looseType(field.Type)
+			fields = append(fields, field)
+		}
+		return reflect.StructOf(fields)
+	case reflect.Ptr:
+		return reflect.PtrTo(looseType(t.Elem()))
+	case reflect.Slice:
+		return reflect.SliceOf(looseType(t.Elem()))
+	case reflect.Map:
+		return reflect.MapOf(t.Key(), looseType(t.Elem()))
+	}
+	return t
+}
+
+// migrateTimestamps converts the createTime and updateTime strings of
+// environments, in TimeLayout and local time, to timestamps.
+func migrateTimestamps(doc yaml.MapSlice) error {
+	envs, _ := lookup(doc, "envs")
+	list, _ := envs.([]interface{})
+	for _, item := range list {
+		env, ok := item.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		for i := range env {
+			key := env[i].Key
+			if key != "createTime" && key != "updateTime" {
+				continue
+			}
+			text, ok := env[i].Value.(string)
+			if !ok {
+				continue
+			}
+			text = strings.TrimSpace(text)
+			if text == "" || text == "--" {
+				env[i].Value = nil
+				continue
+			}
+			t, err := time.ParseInLocation(TimeLayout, text, time.Local)
+			if err != nil {
+				return fmt.Errorf("environment %v: invalid %s %q", valueOf(env, "id"), key, text)
+			}
+			env[i].Value = t
+		}
+	}
+	return nil
+}
+
+func lookup(doc yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range doc {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+func valueOf(doc yaml.MapSlice, key string) interface{} {
+	value, _ := lookup(doc, key)
+	return value
+}
+
+// set sets key in doc, adding it first when it is missing.
+func set(doc yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(yaml.MapSlice{{Key: key, Value: value}}, doc...)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		data        string
+		wantVersion int
+		wantCreate  time.Time
+		wantErr     string
+	}{
+		{name: "empty", data: "", wantVersion: 0},
+		{name: "current", data: "version: 1\nenvs:\n- {id: dev, createTime: 2024-01-02T15:04:05Z}\n",
+			wantVersion: 1, wantCreate: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{name: "legacy timestamps", data: "envs:\n- {id: dev, createTime: '2024-01-02 15:04:05'}\n",
+			wantVersion: 0, wantCreate: created},
+		{name: "legacy placeholder timestamps", data: "envs:\n- {id: dev, createTime: '--', updateTime: ''}\n",
+			wantVersion: 0},
+		{name: "legacy invalid timestamp", data: "envs:\n- {id: dev, createTime: yesterday}\n",
+			wantErr: `environment dev: invalid createTime "yesterday"`},
+		{name: "newer version", data: "version: 2\nenvs: []\n", wantErr: "newer than this devctl supports"},
+		{name: "invalid version", data: "version: one\nenvs: []\n", wantErr: "invalid config version"},
+		{name: "unknown field", data: "version: 1\nenvs:\n- {id: dev, colour: red}\n", wantErr: "field colour not found"},
+		{name: "legacy unknown field", data: "envs:\n- {id: dev, colour: red}\n", wantErr: "line 2: field colour not found"},
+		{name: "legacy unknown field line", data: "# devctl\nenvs:\n- id: dev\n  createTime: '2024-01-02 15:04:05'\n\n  colour: red\n",
+			wantErr: "line 6: field colour not found"},
+		{name: "legacy invalid field", data: "envs:\n- id: dev\n  ip: [10.0.0.1]\n", wantErr: "line 3: cannot unmarshal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, version, err := decodeConfig([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeConfig: %v", err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("migrated from version %d, want %d", version, tt.wantVersion)
+			}
+			if len(config.Envs) > 0 && !config.Envs[0].CreateTime.Equal(tt.wantCreate) {
+				t.Errorf("createTime = %v, want %v", config.Envs[0].CreateTime, tt.wantCreate)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMigratesOnce(t *testing.T) {
+	dir := setupHome(t)
+	writeFile(t, filepath.Join(dir, "config.yaml"), "envs:\n- {id: dev, name: Dev, ip: 10.0.0.1, user: root, createTime: '2024-01-02 15:04:05'}\n")
+
+	for i := 0; i < 2; i++ {
+		if _, err := LoadConfig(newTestLogger(t)); err != nil {
+			t.Fatalf("LoadConfig: %v", err)
+		}
+	}
+
+	backups, err := listBackupFiles(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("found %d backups, want only the one taken by the migration", len(backups))
+	}
+	config, err := ReadConfig(newTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Version != CurrentVersion {
+		t.Errorf("version = %d, want %d", config.Version, CurrentVersion)
+	}
+}
@@ -0,0 +1,377 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jd/devctl/credential"
+	"github.com/jd/devctl/logger"
+)
+
+// verifierText is sealed into the config header so a wrong passphrase is
+// detected before any password is decrypted.
+const verifierText = "devctl"
+
+// Secrets describes the master key sealing the secrets of the config. It is
+// derived from a passphrase with scrypt, unless KDF is set to keyfile to use a
+// random key kept in ~/.devctl/master.key instead, which is only as safe as
+// that directory.
+type Secrets struct {
+	KDF      string `yaml:"kdf"`
+	Salt     string `yaml:"salt,omitempty"`
+	Verifier string `yaml:"verifier,omitempty"`
+}
+
+// Credentials selects where new passwords are written. Existing references are
+// always resolved by their scheme, whatever the backend.
+type Credentials struct {
+	Backend string              `yaml:"backend,omitempty"`
+	Command *CredentialCommands `yaml:"command,omitempty"`
+}
+
+type CredentialCommands struct {
+	Get    string `yaml:"get"`
+	Set    string `yaml:"set"`
+	Delete string `yaml:"delete,omitempty"`
+}
+
+// CredentialStore returns the store used to resolve and persist passwords.
+func (c *Config) CredentialStore() (credential.CredentialStore, error) {
+	if c.store != nil {
+		return c.store, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var commands credential.CommandStore
+	if c.Credentials != nil && c.Credentials.Command != nil {
+		commands = credential.CommandStore{
+			GetCommand:    c.Credentials.Command.Get,
+			SetCommand:    c.Credentials.Command.Set,
+			DeleteCommand: c.Credentials.Command.Delete,
+		}
+	}
+
+	credentialsPath := filepath.Join(home, ".devctl", "credentials.yaml")
+	stores := map[string]credential.CredentialStore{
+		"enc": credential.SealedStore{Sealer: c.getSealer},
+		credential.BackendFile: credential.FileStore{
+			Path:   credentialsPath,
+			Sealer: c.getSealer,
+			Lock:   func() (func(), error) { return lockConfig(credentialsPath) },
+		},
+		credential.BackendKeyring: credential.KeyringStore{},
+		"cmd":                     commands,
+	}
+
+	var backend credential.CredentialStore
+	switch name := c.credentialBackend(); name {
+	case credential.BackendPlaintext:
+		backend = credential.PlainStore{}
+	case credential.BackendEncrypted:
+		backend = stores["enc"]
+	case credential.BackendFile, credential.BackendKeyring:
+		backend = stores[name]
+	case credential.BackendCommand:
+		if commands.GetCommand == "" || commands.SetCommand == "" {
+			return nil, fmt.Errorf("credential backend command requires get and set commands")
+		}
+		backend = commands
+	default:
+		return nil, fmt.Errorf("unsupported credential backend: %s", name)
+	}
+
+	c.store = credential.NewResolver(backend, stores)
+	return c.store, nil
+}
+
+// StorePassword hands a plaintext password, escaped as by credential.Escape,
+// to the configured backend and returns the value to keep in the config
+// file. References pass through.
+func (c *Config) StorePassword(envID, password string) (string, error) {
+	if !c.needsStoring(password) {
+		return password, nil
+	}
+
+	store, err := c.CredentialStore()
+	if err != nil {
+		return "", err
+	}
+	return store.Set(envID, credential.Unescape(password))
+}
+
+// forgetSecret removes the secret ref points to from its backend.
+func (c *Config) forgetSecret(ref string) error {
+	store, err := c.CredentialStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(ref)
+}
+
+// secretRefs returns the credential references used by the environments of c.
+func (c *Config) secretRefs() map[string]bool {
+	refs := make(map[string]bool)
+	for _, env := range c.Envs {
+		for _, field := range env.secretFields() {
+			if credential.IsReference(*field.value) {
+				refs[*field.value] = true
+			}
+		}
+	}
+	return refs
+}
+
+// secretField is a secret-bearing field of an environment together with the
+// credential key it is stored under. Keys join the environment ID and the
+// field with '/', which IDs cannot contain, so no two fields share a key.
+type secretField struct {
+	key   string
+	name  string
+	value *string
+}
+
+func (env *Environment) secretFields() []secretField {
+	fields := []secretField{
+		{key: env.ID, name: "password", value: &env.Password},
+		{key: env.ID + "/key", name: "key passphrase", value: &env.KeyPassphrase},
+	}
+	for i := range env.JumpHosts {
+		fields = append(fields, env.JumpHosts[i].secretFields(fmt.Sprintf("%s/jump%d", env.ID, i+1), fmt.Sprintf("jump host %d", i+1))...)
+	}
+	for i := range env.NodeLogins {
+		cluster := env.NodeLogins[i].Cluster
+		if cluster == "" {
+			cluster = "all"
+		}
+		fields = append(fields, env.NodeLogins[i].secretFields(fmt.Sprintf("%s/node/%s", env.ID, cluster), fmt.Sprintf("node login for %s", cluster))...)
+	}
+	return fields
+}
+
+func (auth *SSHAuth) secretFields(key, name string) []secretField {
+	return []secretField{
+		{key: key, name: name + " password", value: &auth.Password},
+		{key: key + "/key", name: name + " key passphrase", value: &auth.KeyPassphrase},
+	}
+}
+
+func (c *Config) credentialBackend() string {
+	if c.Credentials == nil || c.Credentials.Backend == "" {
+		return credential.BackendEncrypted
+	}
+	return c.Credentials.Backend
+}
+
+func (c *Config) needsStoring(password string) bool {
+	return password != "" && password != "--" && !credential.IsReference(password) &&
+		c.credentialBackend() != credential.BackendPlaintext
+}
+
+// needsSealer reports whether resolving the secrets of this config will
+// require the master key.
+func (c *Config) needsSealer() bool {
+	switch c.credentialBackend() {
+	case credential.BackendEncrypted, credential.BackendFile:
+		if c.hasPlaintextPasswords() {
+			return true
+		}
+	}
+	for _, env := range c.Envs {
+		for _, field := range env.secretFields() {
+			switch credential.Scheme(*field.value) {
+			case "enc", credential.BackendFile:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Config) hasPlaintextPasswords() bool {
+	for _, env := range c.Envs {
+		for _, field := range env.secretFields() {
+			if c.needsStoring(*field.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Config) storePasswords() error {
+	for i := range c.Envs {
+		if err := c.StoreSecrets(&c.Envs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StoreSecrets moves the plaintext passwords and key passphrases of env into
+// the credential store, replacing them with references.
+func (c *Config) StoreSecrets(env *Environment) error {
+	return c.storeSecrets(env, "")
+}
+
+// storeSecrets is StoreSecrets storing each secret under its usual key
+// followed by suffix.
+func (c *Config) storeSecrets(env *Environment, suffix string) error {
+	for _, field := range env.secretFields() {
+		ref, err := c.StorePassword(field.key+suffix, *field.value)
+		if err != nil {
+			return fmt.Errorf("failed to store %s of environment %s: %v", field.name, env.ID, err)
+		}
+		*field.value = ref
+	}
+	return nil
+}
+
+func (c *Config) getSealer() (*credential.Sealer, error) {
+	if c.sealer != nil {
+		return c.sealer, nil
+	}
+
+	if c.Secrets == nil {
+		secrets, err := newSecrets()
+		if err != nil {
+			return nil, err
+		}
+		c.Secrets = secrets
+	}
+
+	key, err := loadMasterKey(c.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	sealer := credential.NewSealer(key)
+
+	if c.Secrets.Verifier == "" {
+		verifier, err := sealer.Seal(verifierText)
+		if err != nil {
+			return nil, err
+		}
+		c.Secrets.Verifier = verifier
+	} else if text, err := sealer.Open(c.Secrets.Verifier); err != nil || text != verifierText {
+		return nil, fmt.Errorf("invalid master key for config secrets")
+	}
+
+	c.sealer = sealer
+	return sealer, nil
+}
+
+// newSecrets sets up a passphrase-derived master key.
+func newSecrets() (*Secrets, error) {
+	salt, err := credential.NewSalt()
+	if err != nil {
+		return nil, err
+	}
+	return &Secrets{KDF: credential.KDFScrypt, Salt: base64.StdEncoding.EncodeToString(salt)}, nil
+}
+
+func loadMasterKey(secrets *Secrets) ([32]byte, error) {
+	switch secrets.KDF {
+	case credential.KDFKeyFile:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return [32]byte{}, err
+		}
+		return credential.LoadKeyFile(filepath.Join(home, ".devctl", "master.key"))
+	case credential.KDFScrypt:
+		salt, err := base64.StdEncoding.DecodeString(secrets.Salt)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("invalid secrets salt: %v", err)
+		}
+		var passphrase string
+		if secrets.Verifier == "" {
+			passphrase, err = credential.ReadNewPassphrase("New devctl master passphrase: ")
+		} else {
+			passphrase, err = credential.ReadPassphrase("devctl master passphrase: ")
+		}
+		if err != nil {
+			return [32]byte{}, err
+		}
+		return credential.DeriveKey(passphrase, salt)
+	default:
+		return [32]byte{}, fmt.Errorf("unsupported secrets kdf: %s", secrets.KDF)
+	}
+}
+
+// UnlockSecrets derives the master key now when the credential backend or
+// the stored secrets will need it, so that the UI never has to prompt for it.
+// Other callers unlock lazily on the first sealed secret they resolve.
+func (c *Config) UnlockSecrets() error {
+	switch c.credentialBackend() {
+	case credential.BackendEncrypted, credential.BackendFile:
+	default:
+		if !c.needsSealer() {
+			return nil
+		}
+	}
+	_, err := c.getSealer()
+	return err
+}
+
+// scrubBackups moves plaintext passwords left behind in earlier backups into
+// the credential store. They are stored under keys of their own backup, as
+// "<key>@config_<timestamp>", so that they never overwrite the current ones.
+func (c *Config) scrubBackups(configPath string, log *logger.Logger) error {
+	backups, err := filepath.Glob(filepath.Join(filepath.Dir(configPath), "backups", "config_*.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for _, backupPath := range backups {
+		data, err := ioutil.ReadFile(backupPath)
+		if err != nil {
+			log.Error("Failed to read backup %s: %v", backupPath, err)
+			continue
+		}
+
+		backup, _, err := decodeConfig(data)
+		if err != nil {
+			log.Error("Failed to parse backup %s: %v", backupPath, err)
+			continue
+		}
+		backup.Credentials = c.Credentials
+		if !backup.hasPlaintextPasswords() {
+			continue
+		}
+		if backup.Secrets != nil && c.Secrets != nil && *backup.Secrets != *c.Secrets {
+			log.Warning("Skipping backup %s encrypted with a different master key", backupPath)
+			continue
+		}
+
+		if c.Secrets != nil {
+			backup.Secrets = c.Secrets
+		}
+		backup.sealer = c.sealer
+		backup.store = c.store
+		name := strings.TrimSuffix(filepath.Base(backupPath), ".yaml")
+		for i := range backup.Envs {
+			if err := backup.storeSecrets(&backup.Envs[i], "@"+name); err != nil {
+				return err
+			}
+		}
+
+		backup.lower = c.lower
+		data, err = backup.marshal()
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(backupPath, data, 0600); err != nil {
+			return err
+		}
+		os.Chmod(backupPath, 0600)
+		log.Info("Removed plaintext passwords from backup: %s", backupPath)
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jd/devctl/credential"
+	"golang.org/x/term"
+)
+
+func TestGetSealer(t *testing.T) {
+	tests := []struct {
+		name        string
+		secrets     *Secrets
+		wantKDF     string
+		wantKeyFile bool
+	}{
+		{name: "passphrase by default", wantKDF: credential.KDFScrypt},
+		{name: "key file when opted in", secrets: &Secrets{KDF: credential.KDFKeyFile}, wantKDF: credential.KDFKeyFile, wantKeyFile: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupHome(t)
+			c := &Config{Secrets: tt.secrets}
+			sealer, err := c.getSealer()
+			if err != nil {
+				t.Fatalf("getSealer: %v", err)
+			}
+			if c.Secrets.KDF != tt.wantKDF {
+				t.Errorf("kdf = %q, want %q", c.Secrets.KDF, tt.wantKDF)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "master.key")); (err == nil) != tt.wantKeyFile {
+				t.Errorf("master.key exists = %v, want %v", err == nil, tt.wantKeyFile)
+			}
+
+			sealed, err := sealer.Seal("s3cret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			reopened := &Config{Secrets: c.Secrets}
+			if _, err := reopened.getSealer(); err != nil {
+				t.Fatalf("getSealer with the same key: %v", err)
+			}
+			if text, err := reopened.sealer.Open(sealed); err != nil || text != "s3cret" {
+				t.Errorf("Open = %q, %v, want s3cret", text, err)
+			}
+		})
+	}
+}
+
+func TestGetSealerWrongPassphrase(t *testing.T) {
+	setupHome(t)
+	c := &Config{}
+	if _, err := c.getSealer(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(credential.PassphraseEnv, "wrong passphrase")
+	if _, err := (&Config{Secrets: c.Secrets}).getSealer(); err == nil {
+		t.Error("getSealer accepted a wrong passphrase")
+	}
+}
+
+func TestGetSealerRequiresPassphrase(t *testing.T) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal, getSealer would prompt")
+	}
+	setupHome(t)
+	t.Setenv(credential.PassphraseEnv, "")
+	if _, err := (&Config{}).getSealer(); err == nil {
+		t.Error("getSealer did not ask for a passphrase")
+	}
+}
+
+func TestStorePasswordLookingLikeReference(t *testing.T) {
+	tests := []struct {
+		backend string
+		wantRef string
+	}{
+		{backend: credential.BackendEncrypted},
+		{backend: credential.BackendFile, wantRef: "file:dev"},
+		{backend: credential.BackendPlaintext, wantRef: "plain:keyring:dev"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			setupHome(t)
+			c := &Config{Credentials: &Credentials{Backend: tt.backend}}
+			ref, err := c.StorePassword("dev", credential.Escape("keyring:dev"))
+			if err != nil {
+				t.Fatalf("StorePassword: %v", err)
+			}
+			if tt.wantRef != "" && ref != tt.wantRef {
+				t.Errorf("reference = %q, want %q", ref, tt.wantRef)
+			}
+			store, err := c.CredentialStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if password, err := store.Get(ref); err != nil || password != "keyring:dev" {
+				t.Errorf("password = %q, %v, want keyring:dev", password, err)
+			}
+		})
+	}
+}
+
+func TestFileBackendConcurrentInstances(t *testing.T) {
+	setupHome(t)
+	first := &Config{Credentials: &Credentials{Backend: credential.BackendFile}}
+	if _, err := first.getSealer(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each config stands for another devctl instance, sharing only the files
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &Config{Credentials: first.Credentials, Secrets: first.Secrets, sealer: first.sealer}
+			if _, err := c.StorePassword(fmt.Sprintf("env%d", i), fmt.Sprintf("secret%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := first.CredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		ref := fmt.Sprintf("file:env%d", i)
+		if got, err := store.Get(ref); err != nil || got != fmt.Sprintf("secret%d", i) {
+			t.Errorf("Get(%s) = %q, %v", ref, got, err)
+		}
+	}
+}
+
+func TestStoreSecretsKeysDoNotCollide(t *testing.T) {
+	setupHome(t)
+	a := Environment{ID: "a", Password: "a password", KeyPassphrase: "a key passphrase",
+		JumpHosts:  []JumpHost{{Host: "10.0.0.2", SSHAuth: SSHAuth{User: "root", Password: "a jump password"}}},
+		NodeLogins: []NodeLogin{{Cluster: "x", SSHAuth: SSHAuth{User: "root", Password: "a node password"}}}}
+	c := &Config{
+		Credentials: &Credentials{Backend: credential.BackendFile},
+		Envs: []Environment{a,
+			{ID: "a-key", Password: "a-key password"},
+			{ID: "a-jump1", Password: "a-jump1 password"},
+			{ID: "a-node-x", Password: "a-node-x password"},
+		},
+	}
+	want := []map[string]string{
+		{"password": "a password", "key passphrase": "a key passphrase",
+			"jump host 1 password": "a jump password", "node login for x password": "a node password"},
+		{"password": "a-key password"},
+		{"password": "a-jump1 password"},
+		{"password": "a-node-x password"},
+	}
+
+	if err := c.storePasswords(); err != nil {
+		t.Fatalf("storePasswords: %v", err)
+	}
+	store, err := c.CredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range c.Envs {
+		env := &c.Envs[i]
+		for _, field := range env.secretFields() {
+			if *field.value == "" {
+				continue
+			}
+			got, err := store.Get(*field.value)
+			if err != nil || got != want[i][field.name] {
+				t.Errorf("%s of %s (%s) = %q, %v, want %q", field.name, env.ID, *field.value, got, err, want[i][field.name])
+			}
+		}
+	}
+}
+
+func TestLoadConfigLeavesSecretsLocked(t *testing.T) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		t.Skip("stdin is a terminal, unlocking would prompt")
+	}
+	dir := setupHome(t)
+	writeFile(t, filepath.Join(dir, "config.yaml"), "version: 1\ncredentials: {backend: encrypted}\nenvs:\n- {id: dev, ip: 10.0.0.1, user: root, password: s3cret}\n")
+	if _, err := LoadConfig(newTestLogger(t)); err != nil {
+		t.Fatalf("LoadConfig sealing the password: %v", err)
+	}
+
+	// Commands that never touch a secret must not need the passphrase
+	t.Setenv(credential.PassphraseEnv, "")
+	config, err := LoadConfig(newTestLogger(t))
+	if err != nil {
+		t.Fatalf("LoadConfig without a passphrase: %v", err)
+	}
+	if err := config.UnlockSecrets(); err == nil {
+		t.Error("UnlockSecrets did not ask for a passphrase")
+	}
+}
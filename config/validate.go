@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Problem is an invalid setting found by Validate. EnvID is empty for
+// problems with the config file as a whole.
+type Problem struct {
+	EnvID   string
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.EnvID == "" && p.Field == "":
+		return p.Message
+	case p.EnvID == "":
+		return fmt.Sprintf("%s: %s", p.Field, p.Message)
+	case p.Field == "":
+		return fmt.Sprintf("environment %s: %s", p.EnvID, p.Message)
+	default:
+		return fmt.Sprintf("environment %s: %s: %s", p.EnvID, p.Field, p.Message)
+	}
+}
+
+var (
+	// IDs name directories under ~/.devctl, so they must be safe as paths
+	envIDPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?\.)*[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+)
+
+// Validate checks the config for problems devctl cannot work around, such as
+// duplicate environment IDs or kubeconfigs that no longer exist.
+func (c *Config) Validate() []Problem {
+	var problems []Problem
+	for _, err := range c.layerErrors {
+		problems = append(problems, Problem{Message: err.Error()})
+	}
+	problems = append(problems, c.validateLaunchers()...)
+
+	seen := make(map[string]bool)
+	for _, env := range c.Envs {
+		if seen[env.ID] {
+			problems = append(problems, Problem{EnvID: env.ID, Field: "id",
+				Message: "duplicate ID, rename or delete one of the environments"})
+		}
+		seen[env.ID] = true
+
+		problems = append(problems, env.Validate()...)
+		// Shared environments download their kubeconfig on first use
+		// The default environment holds $KUBECONFIG, which may list several files
+		if _, shared := c.LowerLayer(env.ID); env.Kubeconfig != "" && !shared {
+			for _, path := range filepath.SplitList(env.Kubeconfig) {
+				if _, err := os.Stat(path); err != nil {
+					problems = append(problems, Problem{EnvID: env.ID, Field: "kubeconfig",
+						Message: fmt.Sprintf("%s does not exist, fix the path or re-add the environment to download it again", path)})
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// Validate checks the settings of env that do not depend on the filesystem.
+func (env Environment) Validate() []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{EnvID: env.ID, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !envIDPattern.MatchString(env.ID) {
+		add("id", "%q is not a valid ID, use letters, digits, '.', '_' and '-' only, starting with a letter or digit", env.ID)
+	}
+	// The default environment only wraps a local kubeconfig
+	if env.ID == "default" {
+		return problems
+	}
+
+	if env.IP == "" {
+		add("ip", "jump host address is empty")
+	} else if !validHost(env.IP) {
+		add("ip", "%q is not a valid IP address or hostname", env.IP)
+	}
+	if env.User == "" {
+		add("user", "jump host user is empty")
+	}
+	for i, jump := range env.JumpHosts {
+		if !validHost(jump.Host) {
+			add(fmt.Sprintf("jumpHosts[%d].host", i), "%q is not a valid IP address or hostname", jump.Host)
+		}
+	}
+	if env.Proxy != "" {
+		if u, err := url.Parse(env.Proxy); err != nil || u.Host == "" {
+			add("proxy", "%q is not a valid URL", env.Proxy)
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			add("proxy", "unsupported scheme %q, use http, https or socks5", u.Scheme)
+		}
+	}
+	return problems
+}
+
+func validHost(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	return len(host) <= 253 && hostnamePattern.MatchString(host)
+}
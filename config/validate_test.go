@@ -0,0 +1,84 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvironmentValidate(t *testing.T) {
+	valid := Environment{ID: "dev", IP: "10.0.0.1", User: "root"}
+	with := func(change func(*Environment)) Environment {
+		env := valid
+		change(&env)
+		return env
+	}
+
+	tests := []struct {
+		name string
+		env  Environment
+		want string
+	}{
+		{name: "valid", env: valid},
+		{name: "hostname", env: with(func(e *Environment) { e.IP = "bastion.example.com" })},
+		{name: "default", env: Environment{ID: "default", IP: "--", User: "--"}},
+		{name: "invalid ID", env: with(func(e *Environment) { e.ID = "../dev" }), want: `id: "../dev" is not a valid ID`},
+		{name: "empty ID", env: with(func(e *Environment) { e.ID = "" }), want: `id: "" is not a valid ID`},
+		{name: "no IP", env: with(func(e *Environment) { e.IP = "" }), want: "ip: jump host address is empty"},
+		{name: "invalid IP", env: with(func(e *Environment) { e.IP = "10.0.0.1:22" }), want: `ip: "10.0.0.1:22" is not a valid IP address or hostname`},
+		{name: "no user", env: with(func(e *Environment) { e.User = "" }), want: "user: jump host user is empty"},
+		{name: "invalid jump host", env: with(func(e *Environment) { e.JumpHosts = []JumpHost{{Host: "-bad"}} }),
+			want: `jumpHosts[0].host: "-bad" is not a valid IP address or hostname`},
+		{name: "proxy", env: with(func(e *Environment) { e.Proxy = "socks5://127.0.0.1:1080" })},
+		{name: "invalid proxy", env: with(func(e *Environment) { e.Proxy = "127.0.0.1:1080" }), want: "proxy:"},
+		{name: "unsupported proxy scheme", env: with(func(e *Environment) { e.Proxy = "ftp://proxy:21" }), want: `unsupported scheme "ftp"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := tt.env.Validate()
+			switch {
+			case tt.want == "" && len(problems) > 0:
+				t.Errorf("unexpected problems %v", problems)
+			case tt.want != "" && (len(problems) != 1 || !strings.Contains(problems[0].String(), tt.want)):
+				t.Errorf("problems = %v, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	writeFile(t, kubeconfig, "")
+	dev := Environment{ID: "dev", IP: "10.0.0.1", User: "root", Kubeconfig: kubeconfig}
+
+	tests := []struct {
+		name string
+		envs []Environment
+		want []string
+	}{
+		{name: "valid", envs: []Environment{dev}},
+		{name: "duplicate ID", envs: []Environment{dev, dev}, want: []string{"environment dev: id: duplicate ID"}},
+		{name: "missing kubeconfig", envs: []Environment{{ID: "dev", IP: "10.0.0.1", User: "root", Kubeconfig: kubeconfig + ".gone"}},
+			want: []string{"environment dev: kubeconfig: " + kubeconfig + ".gone does not exist"}},
+		{name: "kubeconfig list", envs: []Environment{{ID: "default", Kubeconfig: kubeconfig + string(filepath.ListSeparator) + kubeconfig}}},
+		{name: "kubeconfig list with a missing file", envs: []Environment{{ID: "default", Kubeconfig: kubeconfig + string(filepath.ListSeparator) + kubeconfig + ".gone"}},
+			want: []string{"environment default: kubeconfig: " + kubeconfig + ".gone does not exist"}},
+		{name: "several problems", envs: []Environment{{ID: "dev"}},
+			want: []string{"ip: jump host address is empty", "user: jump host user is empty"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := (&Config{Envs: tt.envs}).Validate()
+			if len(problems) != len(tt.want) {
+				t.Fatalf("problems = %v, want %d", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i].String(), want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
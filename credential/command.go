@@ -0,0 +1,105 @@
+package credential
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// KeyringStore talks to the freedesktop Secret Service over D-Bus through
+// secret-tool, referenced from the config as "keyring:<key>".
+type KeyringStore struct{}
+
+func (KeyringStore) Get(ref string) (string, error) {
+	key := strings.TrimPrefix(ref, BackendKeyring+":")
+	out, err := runCommand(nil, "secret-tool", "lookup", "service", "devctl", "account", key)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s in keyring: %v", key, err)
+	}
+	return strings.TrimRight(out, "\r\n"), nil
+}
+
+func (KeyringStore) Set(key, secret string) (string, error) {
+	label := fmt.Sprintf("devctl %s", key)
+	if _, err := runCommand(strings.NewReader(secret), "secret-tool", "store", "--label", label, "service", "devctl", "account", key); err != nil {
+		return "", fmt.Errorf("failed to store %s in keyring: %v", key, err)
+	}
+	return BackendKeyring + ":" + key, nil
+}
+
+func (KeyringStore) Delete(ref string) error {
+	key := strings.TrimPrefix(ref, BackendKeyring+":")
+	if _, err := runCommand(nil, "secret-tool", "clear", "service", "devctl", "account", key); err != nil {
+		return fmt.Errorf("failed to remove %s from keyring: %v", key, err)
+	}
+	return nil
+}
+
+// CommandStore delegates to an external password manager such as pass, gopass
+// or a vault CLI, referenced from the config as "cmd:<key>". Each command is
+// run through sh with {key} replaced by the quoted key; Set receives the secret
+// on stdin and Get uses the first line of output.
+type CommandStore struct {
+	GetCommand    string
+	SetCommand    string
+	DeleteCommand string
+}
+
+func (s CommandStore) Get(ref string) (string, error) {
+	key := strings.TrimPrefix(ref, "cmd:")
+	if s.GetCommand == "" {
+		return "", fmt.Errorf("no get command configured for credential %s", key)
+	}
+
+	out, err := runCommand(nil, "sh", "-c", expandKey(s.GetCommand, key))
+	if err != nil {
+		return "", fmt.Errorf("failed to get credential %s: %v", key, err)
+	}
+	return strings.SplitN(out, "\n", 2)[0], nil
+}
+
+func (s CommandStore) Set(key, secret string) (string, error) {
+	if s.SetCommand == "" {
+		return "", fmt.Errorf("no set command configured for credential %s", key)
+	}
+
+	if _, err := runCommand(strings.NewReader(secret+"\n"), "sh", "-c", expandKey(s.SetCommand, key)); err != nil {
+		return "", fmt.Errorf("failed to set credential %s: %v", key, err)
+	}
+	return "cmd:" + key, nil
+}
+
+func (s CommandStore) Delete(ref string) error {
+	key := strings.TrimPrefix(ref, "cmd:")
+	if s.DeleteCommand == "" {
+		return nil
+	}
+
+	if _, err := runCommand(nil, "sh", "-c", expandKey(s.DeleteCommand, key)); err != nil {
+		return fmt.Errorf("failed to delete credential %s: %v", key, err)
+	}
+	return nil
+}
+
+func expandKey(command, key string) string {
+	return strings.ReplaceAll(command, "{key}", "'"+strings.ReplaceAll(key, "'", `'\''`)+"'")
+}
+
+func runCommand(stdin *strings.Reader, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
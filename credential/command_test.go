@@ -0,0 +1,61 @@
+package credential
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCommandStore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands run through sh")
+	}
+	dir := t.TempDir()
+	store := CommandStore{
+		GetCommand:    "cat " + dir + "/{key}",
+		SetCommand:    "cat > " + dir + "/{key}",
+		DeleteCommand: "rm " + dir + "/{key}",
+	}
+
+	tests := []struct {
+		key    string
+		secret string
+	}{
+		{key: "dev", secret: "s3cret"},
+		{key: "it's; rm -rf x", secret: "with spaces"},
+		{key: "multiline", secret: "first\nsecond"},
+	}
+
+	for _, tt := range tests {
+		ref, err := store.Set(tt.key, tt.secret)
+		if err != nil {
+			t.Fatalf("Set(%q): %v", tt.key, err)
+		}
+		if ref != "cmd:"+tt.key {
+			t.Errorf("Set(%q) = %q, want cmd:%s", tt.key, ref, tt.key)
+		}
+
+		// Only the first line of output is the secret
+		want := tt.secret
+		if tt.key == "multiline" {
+			want = "first"
+		}
+		if got, err := store.Get(ref); err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+
+		if err := store.Delete(ref); err != nil {
+			t.Errorf("Delete(%q): %v", ref, err)
+		}
+		if _, err := store.Get(ref); err == nil {
+			t.Errorf("Get(%q) succeeded after Delete", ref)
+		}
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*")); len(matches) != 0 {
+		t.Errorf("files left behind: %v", matches)
+	}
+	if _, err := (CommandStore{}).Get("cmd:dev"); err == nil {
+		t.Error("Get without a get command succeeded")
+	}
+}
@@ -0,0 +1,135 @@
+package credential
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileStore keeps secrets in a separate encrypted file, referenced from the
+// config as "file:<key>". Lock, when set, is held while the file is rewritten
+// so that concurrent devctl instances do not drop each other's secrets.
+type FileStore struct {
+	Path   string
+	Sealer func() (*Sealer, error)
+	Lock   func() (unlock func(), err error)
+}
+
+func (s FileStore) Get(ref string) (string, error) {
+	key := strings.TrimPrefix(ref, BackendFile+":")
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, ok := secrets[key]
+	if !ok {
+		return "", fmt.Errorf("credential %s not found in %s", key, s.Path)
+	}
+
+	sealer, err := s.Sealer()
+	if err != nil {
+		return "", err
+	}
+	return sealer.Open(sealed)
+}
+
+func (s FileStore) Set(key, secret string) (string, error) {
+	sealer, err := s.Sealer()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := sealer.Seal(secret)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	secrets[key] = sealed
+	if err := s.save(secrets); err != nil {
+		return "", err
+	}
+	return BackendFile + ":" + key, nil
+}
+
+func (s FileStore) Delete(ref string) error {
+	key := strings.TrimPrefix(ref, BackendFile+":")
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return s.save(secrets)
+}
+
+func (s FileStore) lock() (func(), error) {
+	if s.Lock == nil {
+		return func() {}, nil
+	}
+	return s.Lock()
+}
+
+func (s FileStore) load() (map[string]string, error) {
+	secrets := map[string]string{}
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %v", err)
+	}
+	return secrets, nil
+}
+
+func (s FileStore) save(secrets map[string]string) error {
+	data, err := yaml.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %v", err)
+	}
+
+	// TempFile creates the file readable by its owner only
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to replace credentials file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package credential
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	sealer := NewSealer([32]byte{1})
+	var mu sync.Mutex
+	store := FileStore{
+		Path:   filepath.Join(t.TempDir(), "credentials.yaml"),
+		Sealer: func() (*Sealer, error) { return sealer, nil },
+		Lock: func() (func(), error) {
+			mu.Lock()
+			return mu.Unlock, nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := store.Set(fmt.Sprintf("env%d", i), fmt.Sprintf("secret%d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		ref := fmt.Sprintf("file:env%d", i)
+		if got, err := store.Get(ref); err != nil || got != fmt.Sprintf("secret%d", i) {
+			t.Errorf("Get(%s) = %q, %v", ref, got, err)
+		}
+	}
+
+	if err := store.Delete("file:env0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("file:env0"); err == nil {
+		t.Error("deleted credential can still be read")
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("credentials file mode = %v, want 0600", perm)
+	}
+	if leftovers, _ := filepath.Glob(store.Path + ".tmp*"); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
@@ -0,0 +1,111 @@
+package credential
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// PassphraseEnv lets scripts supply the master passphrase without a prompt.
+const PassphraseEnv = "DEVCTL_MASTER_PASSPHRASE"
+
+const (
+	KDFKeyFile = "keyfile"
+	KDFScrypt  = "scrypt"
+)
+
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	return salt, nil
+}
+
+func DeriveKey(passphrase string, salt []byte) ([32]byte, error) {
+	var key [32]byte
+	derived, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return key, fmt.Errorf("failed to derive key: %v", err)
+	}
+	copy(key[:], derived)
+	return key, nil
+}
+
+// LoadKeyFile reads the random master key at path, generating it on first use.
+func LoadKeyFile(path string) ([32]byte, error) {
+	var key [32]byte
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(data) != len(key) {
+			return key, fmt.Errorf("master key file %s is corrupt", path)
+		}
+		copy(key[:], data)
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return key, fmt.Errorf("failed to read master key file: %v", err)
+	}
+
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, fmt.Errorf("failed to generate master key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return key, fmt.Errorf("failed to create master key directory: %v", err)
+	}
+	if err := ioutil.WriteFile(path, key[:], 0600); err != nil {
+		return key, fmt.Errorf("failed to write master key file: %v", err)
+	}
+	return key, nil
+}
+
+// ReadNewPassphrase is ReadPassphrase for a passphrase being chosen, which
+// must not be empty and is asked twice when prompted for.
+func ReadNewPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	passphrase, err := ReadPassphrase(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("master passphrase cannot be empty")
+	}
+	confirm, err := ReadPassphrase("Confirm master passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != passphrase {
+		return "", fmt.Errorf("master passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// ReadPassphrase returns the master passphrase from PassphraseEnv, falling back
+// to an interactive prompt when stdin is a terminal.
+func ReadPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("master passphrase required: set %s", PassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return string(passphrase), nil
+}
@@ -0,0 +1,58 @@
+package credential
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	key, err := DeriveKey("passphrase", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := DeriveKey("passphrase", salt); again != key {
+		t.Error("the same passphrase and salt derived another key")
+	}
+	if other, _ := DeriveKey("Passphrase", salt); other == key {
+		t.Error("another passphrase derived the same key")
+	}
+	if other, _ := DeriveKey("passphrase", []byte("fedcba9876543210")); other == key {
+		t.Error("another salt derived the same key")
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "master.key")
+	key, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("LoadKeyFile: %v", err)
+	}
+	if key == [32]byte{} {
+		t.Error("generated key is zero")
+	}
+	if info, err := os.Stat(path); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("key file = %v, %v, want mode 0600", info, err)
+	}
+	if again, err := LoadKeyFile(path); err != nil || again != key {
+		t.Errorf("LoadKeyFile did not return the stored key: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeyFile(path); err == nil {
+		t.Error("LoadKeyFile accepted a corrupt key file")
+	}
+}
+
+func TestReadNewPassphraseFromEnv(t *testing.T) {
+	t.Setenv(PassphraseEnv, "from env")
+	if passphrase, err := ReadNewPassphrase("Master passphrase: "); err != nil || passphrase != "from env" {
+		t.Errorf("ReadNewPassphrase = %q, %v, want the %s value", passphrase, err, PassphraseEnv)
+	}
+}
@@ -0,0 +1,58 @@
+package credential
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// SealedPrefix marks a value that has been encrypted with a Sealer.
+const SealedPrefix = "enc:v1:"
+
+type Sealer struct {
+	key [32]byte
+}
+
+func NewSealer(key [32]byte) *Sealer {
+	return &Sealer{key: key}
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SealedPrefix)
+}
+
+func (s *Sealer) Seal(plaintext string) (string, error) {
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	box := secretbox.Seal(nonce[:], []byte(plaintext), &nonce, &s.key)
+	return SealedPrefix + base64.StdEncoding.EncodeToString(box), nil
+}
+
+func (s *Sealer) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return "", fmt.Errorf("value is not sealed")
+	}
+
+	box, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, SealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode sealed value: %v", err)
+	}
+	if len(box) < 24+secretbox.Overhead {
+		return "", fmt.Errorf("sealed value is too short")
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], box[:24])
+	plaintext, ok := secretbox.Open(nil, box[24:], &nonce, &s.key)
+	if !ok {
+		return "", fmt.Errorf("failed to decrypt sealed value, wrong master key?")
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,58 @@
+package credential
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	for _, plaintext := range []string{"", "s3cret", "keyring:dev", "密码", strings.Repeat("x", 4096)} {
+		sealer := NewSealer([32]byte{1})
+		sealed, err := sealer.Seal(plaintext)
+		if err != nil {
+			t.Fatalf("Seal(%q): %v", plaintext, err)
+		}
+		if !IsSealed(sealed) || Scheme(sealed) != "enc" {
+			t.Errorf("Seal(%q) = %q, want an %s value", plaintext, sealed, SealedPrefix)
+		}
+		if again, _ := sealer.Seal(plaintext); again == sealed {
+			t.Errorf("Seal(%q) reused its nonce", plaintext)
+		}
+		if got, err := sealer.Open(sealed); err != nil || got != plaintext {
+			t.Errorf("Open(Seal(%q)) = %q, %v", plaintext, got, err)
+		}
+	}
+}
+
+func TestSealerOpenErrors(t *testing.T) {
+	sealer := NewSealer([32]byte{1})
+	sealed, err := sealer.Seal("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, SealedPrefix))
+	box[len(box)-1] ^= 1
+	tampered := SealedPrefix + base64.StdEncoding.EncodeToString(box)
+
+	tests := []struct {
+		name    string
+		sealer  *Sealer
+		value   string
+		wantErr string
+	}{
+		{name: "not sealed", sealer: sealer, value: "s3cret", wantErr: "not sealed"},
+		{name: "not base64", sealer: sealer, value: SealedPrefix + "!!", wantErr: "failed to decode"},
+		{name: "too short", sealer: sealer, value: SealedPrefix + "AAAA", wantErr: "too short"},
+		{name: "wrong key", sealer: NewSealer([32]byte{2}), value: sealed, wantErr: "wrong master key"},
+		{name: "tampered", sealer: sealer, value: tampered, wantErr: "failed to decrypt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.sealer.Open(tt.value); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Open(%q) = %v, want %q", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
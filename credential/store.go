@@ -0,0 +1,128 @@
+package credential
+
+import (
+	"strings"
+)
+
+const (
+	BackendPlaintext = "plaintext"
+	BackendEncrypted = "encrypted"
+	BackendFile      = "file"
+	BackendKeyring   = "keyring"
+	BackendCommand   = "command"
+)
+
+// CredentialStore resolves and persists jump-host secrets. Get and Delete take
+// the reference stored in the config, Set returns the reference to store.
+type CredentialStore interface {
+	Get(ref string) (string, error)
+	Set(key, secret string) (string, error)
+	Delete(ref string) error
+}
+
+// Resolver dispatches references to the store owning their scheme and writes
+// new secrets to the configured backend. Values without a known scheme are
+// treated as plaintext.
+type Resolver struct {
+	backend CredentialStore
+	stores  map[string]CredentialStore
+}
+
+func NewResolver(backend CredentialStore, stores map[string]CredentialStore) *Resolver {
+	return &Resolver{backend: backend, stores: stores}
+}
+
+func (r *Resolver) Get(ref string) (string, error) {
+	return r.storeFor(ref).Get(ref)
+}
+
+func (r *Resolver) Set(key, secret string) (string, error) {
+	return r.backend.Set(key, secret)
+}
+
+func (r *Resolver) Delete(ref string) error {
+	return r.storeFor(ref).Delete(ref)
+}
+
+func (r *Resolver) storeFor(ref string) CredentialStore {
+	if store, ok := r.stores[Scheme(ref)]; ok {
+		return store
+	}
+	return PlainStore{}
+}
+
+// plainScheme marks a plaintext secret that would otherwise read as a
+// reference, see Escape.
+const plainScheme = "plain"
+
+// Scheme returns the scheme of a credential reference such as "keyring:env1",
+// or "" when the value is not a reference.
+func Scheme(ref string) string {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return ""
+	}
+	switch scheme := ref[:i]; scheme {
+	case "enc", BackendFile, BackendKeyring, "cmd", plainScheme:
+		return scheme
+	}
+	return ""
+}
+
+func IsReference(value string) bool {
+	scheme := Scheme(value)
+	return scheme != "" && scheme != plainScheme
+}
+
+// Escape returns a secret typed by the user as it is kept in the config,
+// prefixed with "plain:" when it would otherwise read as a reference.
+func Escape(secret string) string {
+	if Scheme(secret) != "" {
+		return plainScheme + ":" + secret
+	}
+	return secret
+}
+
+// Unescape returns the secret held by a value that is not a reference.
+func Unescape(value string) string {
+	return strings.TrimPrefix(value, plainScheme+":")
+}
+
+type PlainStore struct{}
+
+func (PlainStore) Get(ref string) (string, error) {
+	return Unescape(ref), nil
+}
+
+func (PlainStore) Set(key, secret string) (string, error) {
+	return Escape(secret), nil
+}
+
+func (PlainStore) Delete(ref string) error {
+	return nil
+}
+
+// SealedStore keeps the secret encrypted inline in the config file.
+type SealedStore struct {
+	Sealer func() (*Sealer, error)
+}
+
+func (s SealedStore) Get(ref string) (string, error) {
+	sealer, err := s.Sealer()
+	if err != nil {
+		return "", err
+	}
+	return sealer.Open(ref)
+}
+
+func (s SealedStore) Set(key, secret string) (string, error) {
+	sealer, err := s.Sealer()
+	if err != nil {
+		return "", err
+	}
+	return sealer.Seal(secret)
+}
+
+func (s SealedStore) Delete(ref string) error {
+	return nil
+}
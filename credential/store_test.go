@@ -0,0 +1,140 @@
+package credential
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScheme(t *testing.T) {
+	tests := []struct {
+		value         string
+		wantScheme    string
+		wantReference bool
+	}{
+		{value: "s3cret"},
+		{value: "", wantScheme: ""},
+		{value: "http://host", wantScheme: ""},
+		{value: "enc:v1:abc", wantScheme: "enc", wantReference: true},
+		{value: "file:dev", wantScheme: BackendFile, wantReference: true},
+		{value: "keyring:dev", wantScheme: BackendKeyring, wantReference: true},
+		{value: "cmd:dev", wantScheme: "cmd", wantReference: true},
+		{value: "plain:keyring:dev", wantScheme: "plain"},
+	}
+
+	for _, tt := range tests {
+		if got := Scheme(tt.value); got != tt.wantScheme {
+			t.Errorf("Scheme(%q) = %q, want %q", tt.value, got, tt.wantScheme)
+		}
+		if got := IsReference(tt.value); got != tt.wantReference {
+			t.Errorf("IsReference(%q) = %v, want %v", tt.value, got, tt.wantReference)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{secret: "s3cret", want: "s3cret"},
+		{secret: "", want: ""},
+		{secret: "keyring:dev", want: "plain:keyring:dev"},
+		{secret: "enc:v1:abc", want: "plain:enc:v1:abc"},
+		{secret: "plain:x", want: "plain:plain:x"},
+	}
+
+	for _, tt := range tests {
+		escaped := Escape(tt.secret)
+		if escaped != tt.want {
+			t.Errorf("Escape(%q) = %q, want %q", tt.secret, escaped, tt.want)
+		}
+		if IsReference(escaped) {
+			t.Errorf("Escape(%q) = %q reads as a reference", tt.secret, escaped)
+		}
+		resolved, err := NewResolver(PlainStore{}, nil).Get(escaped)
+		if err != nil || resolved != tt.secret {
+			t.Errorf("Get(%q) = %q, %v, want %q", escaped, resolved, err, tt.secret)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	sealer := NewSealer([32]byte{1})
+	sealed := SealedStore{Sealer: func() (*Sealer, error) { return sealer, nil }}
+	resolver := NewResolver(sealed, map[string]CredentialStore{"enc": sealed})
+
+	for _, secret := range []string{"s3cret", "keyring:dev"} {
+		ref, err := resolver.Set("dev", secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Scheme(ref) != "enc" {
+			t.Errorf("Set(%q) = %q, want a sealed reference", secret, ref)
+		}
+		if got, err := resolver.Get(ref); err != nil || got != secret {
+			t.Errorf("Get(Set(%q)) = %q, %v", secret, got, err)
+		}
+	}
+}
+
+// memoryStore stands in for the keyring, which needs a D-Bus session.
+type memoryStore map[string]string
+
+func (s memoryStore) Get(ref string) (string, error) {
+	secret, ok := s[ref]
+	if !ok {
+		return "", fmt.Errorf("%s not found", ref)
+	}
+	return secret, nil
+}
+
+func (s memoryStore) Set(key, secret string) (string, error) {
+	s[BackendKeyring+":"+key] = secret
+	return BackendKeyring + ":" + key, nil
+}
+
+func (s memoryStore) Delete(ref string) error {
+	delete(s, ref)
+	return nil
+}
+
+func TestResolverGet(t *testing.T) {
+	sealer := NewSealer([32]byte{1})
+	sealed, err := sealer.Seal("sealed secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := memoryStore{"keyring:dev": "keyring secret"}
+	resolver := NewResolver(keyring, map[string]CredentialStore{
+		"enc":          SealedStore{Sealer: func() (*Sealer, error) { return sealer, nil }},
+		BackendKeyring: keyring,
+	})
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "s3cret", want: "s3cret"},
+		{ref: "", want: ""},
+		{ref: "plain:keyring:dev", want: "keyring:dev"},
+		{ref: "keyring:dev", want: "keyring secret"},
+		{ref: "keyring:prod", wantErr: true},
+		{ref: sealed, want: "sealed secret"},
+		{ref: SealedPrefix + "garbage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolver.Get(tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if err := resolver.Delete("keyring:dev"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keyring["keyring:dev"]; ok {
+		t.Error("Delete did not go to the store owning the reference")
+	}
+}
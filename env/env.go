@@ -47,8 +47,8 @@ func (em *EnvManager) AddDefaultEnvironment() {
 	defaultEnv := config.Environment{
 		ID:         "default",
 		Name:       "默认",
-		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
-		UpdateTime: time.Now().Format("2006-01-02 15:04:05"),
+		CreateTime: time.Now(),
+		UpdateTime: time.Now(),
 		IP:         "--",
 		User:       "--",
 		Password:   "--",
@@ -71,6 +71,11 @@ func (em *EnvManager) AddEnvironment(env config.Environment) error {
 		}
 	}
 
+	if problems := env.Validate(); len(problems) > 0 {
+		em.log.Error("Invalid environment %s: %v", env.ID, problems[0])
+		return fmt.Errorf("%v", problems[0])
+	}
+
 	// Download kubeconfig file
 	kubeconfigPath, err := em.downloadKubeconfig(env)
 	if err != nil {
@@ -78,8 +83,13 @@ func (em *EnvManager) AddEnvironment(env config.Environment) error {
 		return err
 	}
 
+	if err := em.Config.StoreSecrets(&env); err != nil {
+		em.log.Error("Failed to store credentials: %v", err)
+		return err
+	}
+
 	env.Kubeconfig = kubeconfigPath
-	env.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	env.CreateTime = time.Now()
 	env.UpdateTime = env.CreateTime
 	em.Config.Envs = append(em.Config.Envs, env)
 	err = config.SaveConfig(em.Config, em.log)
@@ -92,31 +102,66 @@ func (em *EnvManager) AddEnvironment(env config.Environment) error {
 }
 
 func (em *EnvManager) downloadKubeconfig(env config.Environment) (string, error) {
-	sshClient := ssh.NewSSHClient(env.IP, env.User, env.Password)
+	store, err := em.Config.CredentialStore()
+	if err != nil {
+		return "", fmt.Errorf("failed to open credential store: %v", err)
+	}
+	sshClient := ssh.NewSSHClientForEnv(env, store)
 
 	remoteFile := "/root/.kube/config"
 	localDir := filepath.Join(os.Getenv("HOME"), ".devctl", "kubeconfigs", env.ID)
 	localFile := filepath.Join(localDir, "config")
 
-	err := os.MkdirAll(localDir, 0755)
+	err = os.MkdirAll(localDir, 0755)
 	if err != nil {
 		return "", fmt.Errorf("failed to create local directory: %v", err)
 	}
 
 	err = sshClient.DownloadFile(remoteFile, localFile)
 	if err != nil {
-		return "", fmt.Errorf("failed to download kubeconfig: %v", err)
+		return "", fmt.Errorf("failed to download kubeconfig: %w", err)
 	}
 
 	em.log.Info("Kubeconfig downloaded successfully for environment %s", env.ID)
 	return localFile, nil
 }
 
+// UploadFile pushes a local file, such as an edited kubeconfig or a script, to
+// the management master of the environment.
+func (em *EnvManager) UploadFile(id, localPath, remotePath string) error {
+	em.log.Info("Uploading %s to %s on environment %s", localPath, remotePath, id)
+	env, err := em.GetEnvironment(id)
+	if err != nil {
+		return err
+	}
+
+	store, err := em.Config.CredentialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open credential store: %v", err)
+	}
+
+	if err := ssh.NewSSHClientForEnv(env, store).UploadFile(localPath, remotePath); err != nil {
+		em.log.Error("Failed to upload %s: %v", localPath, err)
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	em.log.Info("File %s uploaded successfully to environment %s", localPath, id)
+	return nil
+}
+
 func (em *EnvManager) UpdateEnvironment(env config.Environment) error {
 	em.log.Info("Updating environment: %s", env.ID)
 	for i, e := range em.Config.Envs {
 		if e.ID == env.ID {
-			env.UpdateTime = time.Now().Format("2006-01-02 15:04:05")
+			if problems := env.Validate(); len(problems) > 0 {
+				em.log.Error("Invalid environment %s: %v", env.ID, problems[0])
+				return fmt.Errorf("%v", problems[0])
+			}
+			if err := em.Config.StoreSecrets(&env); err != nil {
+				em.log.Error("Failed to store credentials: %v", err)
+				return err
+			}
+			env.UpdateTime = time.Now()
 			em.Config.Envs[i] = env
 			err := config.SaveConfig(em.Config, em.log)
 			if err != nil {
@@ -133,6 +178,10 @@ func (em *EnvManager) UpdateEnvironment(env config.Environment) error {
 
 func (em *EnvManager) DeleteEnvironment(id string) error {
 	em.log.Info("Deleting environment: %s", id)
+	if layer, ok := em.Config.LowerLayer(id); ok {
+		em.log.Error("Environment %s comes from the %s config", id, layer)
+		return fmt.Errorf("environment %s comes from the %s config and can only be removed there", id, layer)
+	}
 	for i, e := range em.Config.Envs {
 		if e.ID == id {
 			// First, remove the environment from the config and save it.
@@ -142,6 +191,8 @@ func (em *EnvManager) DeleteEnvironment(id string) error {
 				// If saving fails, the deletion is aborted.
 				return err
 			}
+			// Its secrets are left in the credential store for the backup
+			// just taken, and removed once that backup is pruned
 			em.log.Info("Environment %s removed from config successfully", id)
 
 			// Then, remove the associated kubeconfig directory.
@@ -176,3 +227,35 @@ func (em *EnvManager) GetEnvironment(id string) (config.Environment, error) {
 	em.log.Error("Environment with ID %s not found", id)
 	return config.Environment{}, fmt.Errorf("environment with ID %s not found", id)
 }
+
+// EnsureKubeconfig downloads the kubeconfig of env when it is missing, as for
+// environments shared through the system or team config.
+func (em *EnvManager) EnsureKubeconfig(env config.Environment) error {
+	if env.ID == "default" || env.Kubeconfig == "" {
+		return nil
+	}
+	if _, err := os.Stat(env.Kubeconfig); err == nil {
+		return nil
+	}
+	em.log.Info("Downloading missing kubeconfig of environment %s", env.ID)
+	_, err := em.downloadKubeconfig(env)
+	return err
+}
+
+// RestoreBackup replaces the config with backup. The kubeconfig of a restored
+// environment is downloaded again when it was removed along with the
+// environment; the IDs of those that could not be are returned.
+func (em *EnvManager) RestoreBackup(backup config.Backup) ([]string, error) {
+	if err := em.Config.RestoreBackup(backup, em.log); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, env := range em.Config.Envs {
+		if err := em.EnsureKubeconfig(env); err != nil {
+			em.log.Error("Failed to download kubeconfig of restored environment %s: %v", env.ID, err)
+			missing = append(missing, env.ID)
+		}
+	}
+	return missing, nil
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/jd/devctl/cli"
 	"github.com/jd/devctl/config"
 	"github.com/jd/devctl/env"
 	"github.com/jd/devctl/logger"
@@ -40,23 +42,82 @@ func main() {
 	}
 	defer log.Close()
 
+	// Completion runs on every keystroke, so it must never prompt for the
+	// master passphrase or rewrite the config
+	if len(os.Args) > 1 && os.Args[1] == cli.CompleteCommand {
+		cfg, err := config.ReadConfig(log)
+		if err != nil {
+			cfg = &config.Config{}
+		}
+		cli.NewCLI(cfg, log).Run(os.Args[1:])
+		return
+	}
+
 	log.Info("Starting devctl application")
 
 	// Load configuration
 	cfg, err := config.LoadConfig(log)
-	if err != nil {
+	var problems []config.Problem
+	loadFailed := err != nil && !errors.Is(err, os.ErrNotExist)
+	switch {
+	case err == nil:
+		problems = cfg.Validate()
+	case loadFailed:
+		// Saves refuse to overwrite a file they cannot parse, so running on an
+		// empty config leaves the broken file for the user to fix
 		log.Error("Error loading config: %v", err)
-		fmt.Printf("Error loading config: %v\n", err)
-		log.Info("Using empty config")
-		cfg = &config.Config{} // Use empty config if loading fails
+		problems = []config.Problem{{Message: fmt.Sprintf("failed to load config: %v", err)}}
+		cfg = &config.Config{}
+	default:
+		log.Info("No config file yet, using empty config")
+		cfg = &config.Config{}
 	}
 
 	// Add default environment if it doesn't exist
-	envManager := env.NewEnvManager(cfg, log)
-	envManager.AddDefaultEnvironment()
+	if !loadFailed {
+		envManager := env.NewEnvManager(cfg, log)
+		envManager.AddDefaultEnvironment()
+	}
+
+	// Run a subcommand instead of the UI when one is given
+	if cli.IsKubectlPlugin(os.Args[0]) || len(os.Args) > 1 {
+		if loadFailed {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", problems[0])
+			log.Close()
+			os.Exit(1)
+		}
+
+		c := cli.NewCLI(cfg, log)
+		run := c.Run
+		if cli.IsKubectlPlugin(os.Args[0]) {
+			run = c.RunPlugin
+		}
+		if err := run(os.Args[1:]); err != nil {
+			log.Error("Command failed: %v", err)
+			code := 1
+			var exitErr *cli.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.Code
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+			log.Close()
+			os.Exit(code)
+		}
+		return
+	}
+
+	// The UI owns the terminal, so the master passphrase is asked for now
+	if !loadFailed {
+		if err := cfg.UnlockSecrets(); err != nil {
+			log.Error("Failed to unlock config secrets: %v", err)
+			problems = append(problems, config.Problem{Message: fmt.Sprintf("failed to unlock config secrets: %v", err)})
+		}
+	}
 
 	// Initialize UI
 	ui := ui.NewUI(cfg, log)
+	ui.SetProblems(problems)
 	log.Info("Initializing UI")
 
 	// Run UI
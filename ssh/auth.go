@@ -0,0 +1,191 @@
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jd/devctl/credential"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	AuthPublicKey           = "publickey"
+	AuthAgent               = "agent"
+	AuthPassword            = "password"
+	AuthKeyboardInteractive = "keyboard-interactive"
+)
+
+// DefaultAuthMethods is the order tried when an environment does not set one.
+var DefaultAuthMethods = []string{AuthPublicKey, AuthAgent, AuthPassword, AuthKeyboardInteractive}
+
+// authMethods builds the auth methods for the client in the configured order.
+// The Go SSH client only tries one method per name, so key files and agent
+// keys are merged into a single publickey method at the position of whichever
+// comes first.
+func (c *SSHClient) authMethods() ([]ssh.AuthMethod, func(), error) {
+	order := c.AuthMethods
+	if len(order) == 0 {
+		order = DefaultAuthMethods
+	}
+
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+	var agentConn net.Conn
+	publicKeyIndex := -1
+	cleanup := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+	// The password is only resolved once the server asks for it, so key
+	// logins never unlock the credential store
+	password := c.lazyPassword()
+
+	for _, name := range order {
+		switch strings.TrimSpace(name) {
+		case AuthPublicKey:
+			keySigners, err := c.keyFileSigners()
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			signers = append(signers, keySigners...)
+		case AuthAgent:
+			socket := os.Getenv("SSH_AUTH_SOCK")
+			if socket == "" || agentConn != nil {
+				continue
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				continue
+			}
+			agentConn = conn
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				continue
+			}
+			signers = append(signers, agentSigners...)
+		case AuthPassword:
+			if c.Password != "" {
+				methods = append(methods, ssh.PasswordCallback(password))
+			}
+			continue
+		case AuthKeyboardInteractive:
+			methods = append(methods, ssh.KeyboardInteractive(keyboardInteractive(password)))
+			continue
+		default:
+			cleanup()
+			return nil, nil, fmt.Errorf("unsupported auth method: %s", name)
+		}
+
+		if publicKeyIndex < 0 {
+			publicKeyIndex = len(methods)
+			methods = append(methods, nil)
+		}
+	}
+
+	if publicKeyIndex >= 0 {
+		if len(signers) > 0 {
+			methods[publicKeyIndex] = ssh.PublicKeys(signers...)
+		} else {
+			methods = append(methods[:publicKeyIndex], methods[publicKeyIndex+1:]...)
+		}
+	}
+
+	if len(methods) == 0 {
+		cleanup()
+		return nil, nil, fmt.Errorf("no usable auth method for %s@%s", c.User, c.Host)
+	}
+	return methods, cleanup, nil
+}
+
+func (c *SSHClient) keyFileSigners() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, path := range c.KeyFiles {
+		path = expandHome(path)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(data)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			passphrase, rerr := c.resolve(c.KeyPassphrase)
+			if rerr != nil {
+				return nil, rerr
+			}
+			if passphrase == "" {
+				return nil, fmt.Errorf("key file %s is encrypted but no passphrase is configured", path)
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key file %s: %v", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+func (c *SSHClient) resolve(value string) (string, error) {
+	if c.Store == nil {
+		return credential.Unescape(value), nil
+	}
+	if value == "" {
+		return value, nil
+	}
+	resolved, err := c.Store.Get(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve credential: %v", err)
+	}
+	return resolved, nil
+}
+
+// lazyPassword returns a function resolving the password on its first call
+// and remembering the result for the auth methods that follow.
+func (c *SSHClient) lazyPassword() func() (string, error) {
+	var password string
+	var err error
+	resolved := false
+	return func() (string, error) {
+		if !resolved {
+			password, err = c.resolve(c.Password)
+			resolved = true
+		}
+		return password, err
+	}
+}
+
+// keyboardInteractive answers hidden prompts with the password, which is what
+// PAM-backed bastions ask for.
+func keyboardInteractive(password func() (string, error)) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if echos[i] {
+				continue
+			}
+			secret, err := password()
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = secret
+		}
+		return answers, nil
+	}
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + path[1:]
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+)
+
+// lockedStore fails like a credential store whose master key is not unlocked.
+type lockedStore struct {
+	gets int
+}
+
+func (s *lockedStore) Get(ref string) (string, error) {
+	s.gets++
+	return "", errors.New("master passphrase required")
+}
+
+func (s *lockedStore) Set(key, secret string) (string, error) { return "", nil }
+func (s *lockedStore) Delete(ref string) error                { return nil }
+
+func TestAuthMethodsResolvePasswordLazily(t *testing.T) {
+	store := &lockedStore{}
+	c := &SSHClient{User: "root", Host: "10.0.0.1", Password: "enc:sealed", Store: store,
+		AuthMethods: []string{AuthPassword, AuthKeyboardInteractive}}
+
+	methods, cleanup, err := c.authMethods()
+	if err != nil {
+		t.Fatalf("authMethods: %v", err)
+	}
+	defer cleanup()
+	if len(methods) != 2 {
+		t.Errorf("got %d auth methods, want password and keyboard-interactive", len(methods))
+	}
+	if store.gets != 0 {
+		t.Errorf("password resolved %d times before the server asked for it", store.gets)
+	}
+
+	challenge := keyboardInteractive(c.lazyPassword())
+	if answers, err := challenge("root", "", []string{"Login: "}, []bool{true}); err != nil || store.gets != 0 {
+		t.Errorf("echoed prompt = %q, %v, resolved %d times, want no lookup", answers, err, store.gets)
+	}
+	if _, err := challenge("root", "", []string{"Password: "}, []bool{false}); err == nil {
+		t.Error("hidden prompt did not report the store error")
+	}
+}
@@ -0,0 +1,54 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Run executes command on the host with its stdout and stderr written to
+// output, and returns the command's exit status. Cancelling ctx closes the
+// connection, making Run return ctx's error.
+func (c *SSHClient) Run(ctx context.Context, command string, output io.Writer) (int, error) {
+	client, err := c.Connect()
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.Stdout = output
+	session.Stderr = output
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-done:
+		}
+	}()
+
+	err = session.Run(command)
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return -1, fmt.Errorf("failed to run command: %v", err)
+	}
+}
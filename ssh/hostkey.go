@@ -0,0 +1,175 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// UnknownHostKeyError is returned when a host is not in any known_hosts file.
+// Callers may ask the user to trust the key and call TrustHostKey.
+type UnknownHostKeyError struct {
+	Host string
+	Key  ssh.PublicKey
+}
+
+func (e *UnknownHostKeyError) Error() string {
+	return fmt.Sprintf("unknown host key for %s (%s %s)", e.Host, e.Key.Type(), e.Fingerprint())
+}
+
+func (e *UnknownHostKeyError) Fingerprint() string {
+	return ssh.FingerprintSHA256(e.Key)
+}
+
+// HostKeyChangedError is returned when a host presents a key different from
+// the recorded one. It is never trusted automatically.
+type HostKeyChangedError struct {
+	Host  string
+	Key   ssh.PublicKey
+	Known knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("REMOTE HOST KEY FOR %s HAS CHANGED: got %s %s, expected %s (%s:%d); possible man-in-the-middle attack",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key), ssh.FingerprintSHA256(e.Known.Key), e.Known.Filename, e.Known.Line)
+}
+
+// KnownHostsFiles returns the user's OpenSSH known_hosts followed by the
+// devctl-owned file that TrustHostKey writes to.
+func KnownHostsFiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		filepath.Join(home, ".ssh", "known_hosts"),
+		KnownHostsPath(),
+	}
+}
+
+func KnownHostsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".devctl", "known_hosts")
+}
+
+// TrustHostKey records key for host in the devctl known_hosts file.
+func TrustHostKey(host string, key ssh.PublicKey) error {
+	path := KnownHostsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create known_hosts directory: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known_hosts: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(host)}, key)); err != nil {
+		return fmt.Errorf("failed to write known_hosts: %v", err)
+	}
+	return nil
+}
+
+// hostKeyCallback returns the callback checking the host key against the
+// known_hosts files, and the host key algorithms to offer for the host.
+func (c *SSHClient) hostKeyCallback(remote net.Addr) (ssh.HostKeyCallback, []string, error) {
+	var files []string
+	for _, path := range KnownHostsFiles() {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+
+	check := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return &knownhosts.KeyError{}
+	}
+	if len(files) > 0 {
+		callback, err := knownhosts.New(files...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load known_hosts: %v", err)
+		}
+		check = callback
+	}
+
+	// Servers present their preferred key type, which is taken for a changed
+	// key when known_hosts only records another type, so offer only those.
+	// Certificates still come first, as they would by default, for hosts
+	// trusted through a @cert-authority line.
+	var certAlgorithms, keyAlgorithms []string
+	var keyErr *knownhosts.KeyError
+	if errors.As(check(c.Address(), remote, probeKey{}), &keyErr) {
+		for _, known := range keyErr.Want {
+			certAlgorithms = append(certAlgorithms, hostKeyAlgorithms[certTypes[known.Key.Type()]]...)
+			keyAlgorithms = append(keyAlgorithms, hostKeyAlgorithms[known.Key.Type()]...)
+		}
+	}
+	algorithms := append(certAlgorithms, keyAlgorithms...)
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		c.fingerprint = ssh.FingerprintSHA256(key)
+
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				unknown := &UnknownHostKeyError{Host: hostname, Key: key}
+				if c.AcceptHostKey == nil || !c.AcceptHostKey(unknown) {
+					return unknown
+				}
+				return TrustHostKey(hostname, key)
+			}
+			return &HostKeyChangedError{Host: hostname, Key: key, Known: keyErr.Want[0]}
+		}
+		return err
+	}, algorithms, nil
+}
+
+// certTypes maps host key types to the type of their certificates.
+var certTypes = map[string]string{
+	ssh.KeyAlgoRSA:      ssh.CertAlgoRSAv01,
+	ssh.KeyAlgoDSA:      ssh.CertAlgoDSAv01,
+	ssh.KeyAlgoECDSA256: ssh.CertAlgoECDSA256v01,
+	ssh.KeyAlgoECDSA384: ssh.CertAlgoECDSA384v01,
+	ssh.KeyAlgoECDSA521: ssh.CertAlgoECDSA521v01,
+	ssh.KeyAlgoED25519:  ssh.CertAlgoED25519v01,
+}
+
+// hostKeyAlgorithms maps key and certificate types to the signature
+// algorithms they can be negotiated with.
+var hostKeyAlgorithms = map[string][]string{
+	ssh.KeyAlgoRSA:          {ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+	ssh.KeyAlgoDSA:          {ssh.KeyAlgoDSA},
+	ssh.KeyAlgoECDSA256:     {ssh.KeyAlgoECDSA256},
+	ssh.KeyAlgoECDSA384:     {ssh.KeyAlgoECDSA384},
+	ssh.KeyAlgoECDSA521:     {ssh.KeyAlgoECDSA521},
+	ssh.KeyAlgoED25519:      {ssh.KeyAlgoED25519},
+	ssh.CertAlgoRSAv01:      {ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01},
+	ssh.CertAlgoDSAv01:      {ssh.CertAlgoDSAv01},
+	ssh.CertAlgoECDSA256v01: {ssh.CertAlgoECDSA256v01},
+	ssh.CertAlgoECDSA384v01: {ssh.CertAlgoECDSA384v01},
+	ssh.CertAlgoECDSA521v01: {ssh.CertAlgoECDSA521v01},
+	ssh.CertAlgoED25519v01:  {ssh.CertAlgoED25519v01},
+}
+
+// probeKey matches no known_hosts entry, checking it lists the keys known
+// for a host.
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "probe" }
+func (probeKey) Marshal() []byte                              { return nil }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
+
+// Fingerprint returns the SHA256 fingerprint of the host key seen during the
+// last connection attempt.
+func (c *SSHClient) Fingerprint() string {
+	return c.fingerprint
+}
@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// setupHome points the known_hosts files at an empty temporary home.
+func setupHome(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+}
+
+func newHostKey(t *testing.T, algorithm string) ssh.PublicKey {
+	t.Helper()
+	var public interface{}
+	switch algorithm {
+	case ssh.KeyAlgoED25519:
+		key, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = key
+	case ssh.KeyAlgoECDSA256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+	case ssh.KeyAlgoRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		public = &key.PublicKey
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var bastion = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+func TestTrustHostKey(t *testing.T) {
+	setupHome(t)
+	first, second := newHostKey(t, ssh.KeyAlgoED25519), newHostKey(t, ssh.KeyAlgoECDSA256)
+
+	if err := TrustHostKey("10.0.0.1:22", first); err != nil {
+		t.Fatalf("TrustHostKey: %v", err)
+	}
+	if err := TrustHostKey("10.0.0.2:2222", second); err != nil {
+		t.Fatalf("TrustHostKey: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(KnownHostsPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("known_hosts has %d lines, want one per trusted key:\n%s", len(lines), data)
+	}
+	if !strings.HasPrefix(lines[0], "10.0.0.1 ssh-ed25519 ") || !strings.HasPrefix(lines[1], "[10.0.0.2]:2222 ecdsa-sha2-nistp256 ") {
+		t.Errorf("known_hosts =\n%s", data)
+	}
+}
+
+func TestHostKeyCallbackUnknownKey(t *testing.T) {
+	setupHome(t)
+	key := newHostKey(t, ssh.KeyAlgoED25519)
+	client := NewSSHClient("10.0.0.1", "root", "", nil)
+
+	callback, algorithms, err := client.hostKeyCallback(bastion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if algorithms != nil {
+		t.Errorf("algorithms = %v, want the defaults for an unknown host", algorithms)
+	}
+	var unknown *UnknownHostKeyError
+	if err := callback(client.Address(), bastion, key); !errors.As(err, &unknown) {
+		t.Fatalf("callback = %v, want an UnknownHostKeyError", err)
+	}
+	if unknown.Fingerprint() != ssh.FingerprintSHA256(key) || client.Fingerprint() != unknown.Fingerprint() {
+		t.Errorf("fingerprint = %s, want %s", unknown.Fingerprint(), ssh.FingerprintSHA256(key))
+	}
+
+	// Accepting the key records it, so the next connection checks it
+	var asked int
+	client.AcceptHostKey = func(*UnknownHostKeyError) bool {
+		asked++
+		return true
+	}
+	if err := callback(client.Address(), bastion, key); err != nil || asked != 1 {
+		t.Fatalf("callback = %v after asking %d times, want the key accepted", err, asked)
+	}
+	callback, _, err = NewSSHClient("10.0.0.1", "root", "", nil).hostKeyCallback(bastion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := callback(client.Address(), bastion, key); err != nil {
+		t.Errorf("callback = %v, want the trusted key accepted", err)
+	}
+}
+
+func TestHostKeyCallbackChangedKey(t *testing.T) {
+	setupHome(t)
+	known := newHostKey(t, ssh.KeyAlgoED25519)
+	if err := TrustHostKey("10.0.0.1:22", known); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, algorithm := range []string{ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256} {
+		client := NewSSHClient("10.0.0.1", "root", "", nil)
+		client.AcceptHostKey = func(*UnknownHostKeyError) bool {
+			t.Error("asked to trust a changed key")
+			return true
+		}
+		callback, _, err := client.hostKeyCallback(bastion)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var changed *HostKeyChangedError
+		if err := callback(client.Address(), bastion, newHostKey(t, algorithm)); !errors.As(err, &changed) {
+			t.Fatalf("callback with another %s key = %v, want a HostKeyChangedError", algorithm, err)
+		}
+		if !reflect.DeepEqual(changed.Known.Key.Marshal(), known.Marshal()) {
+			t.Errorf("known key = %s, want %s", ssh.FingerprintSHA256(changed.Known.Key), ssh.FingerprintSHA256(known))
+		}
+	}
+}
+
+func TestHostKeyCallbackAlgorithms(t *testing.T) {
+	tests := []struct {
+		name  string
+		known []string
+		want  []string
+	}{
+		{name: "ed25519", known: []string{ssh.KeyAlgoED25519},
+			want: []string{ssh.CertAlgoED25519v01, ssh.KeyAlgoED25519}},
+		{name: "rsa", known: []string{ssh.KeyAlgoRSA},
+			want: []string{ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSAv01, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}},
+		{name: "several", known: []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519},
+			want: []string{ssh.CertAlgoECDSA256v01, ssh.CertAlgoED25519v01, ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupHome(t)
+			for _, algorithm := range tt.known {
+				if err := TrustHostKey("10.0.0.1:22", newHostKey(t, algorithm)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Another host's keys must not restrict this one
+			if err := TrustHostKey("10.0.0.2:22", newHostKey(t, ssh.KeyAlgoRSA)); err != nil {
+				t.Fatal(err)
+			}
+
+			_, algorithms, err := NewSSHClient("10.0.0.1", "root", "", nil).hostKeyCallback(bastion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The order of the known keys is up to knownhosts, only
+			// certificates must come first
+			for i := 1; i < len(algorithms); i++ {
+				if strings.Contains(algorithms[i], "-cert-") && !strings.Contains(algorithms[i-1], "-cert-") {
+					t.Errorf("algorithms = %v, want certificates first", algorithms)
+				}
+			}
+			got := append([]string(nil), algorithms...)
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("algorithms = %v, want %v", algorithms, tt.want)
+			}
+		})
+	}
+}
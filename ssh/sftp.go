@@ -0,0 +1,177 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// DownloadFile copies remotePath to localPath over SFTP. The data is written
+// to a temporary file next to localPath with mode 0600 and only renamed into
+// place once its size, and checksum when the remote can compute one, match.
+func (c *SSHClient) DownloadFile(remotePath, localPath string) error {
+	client, err := c.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp session: %v", err)
+	}
+	defer sftpClient.Close()
+
+	remoteFile, err := sftpClient.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file: %v", err)
+	}
+	defer remoteFile.Close()
+
+	info, err := remoteFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmpFile.Chmod(0600); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to set local file permissions: %v", err)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmpFile, hash), remoteFile)
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write to local file: %v", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync local file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close local file: %v", err)
+	}
+
+	if written != info.Size() {
+		return fmt.Errorf("incomplete transfer of %s: got %d of %d bytes", remotePath, written, info.Size())
+	}
+	if err := verifyChecksum(client, remotePath, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return fmt.Errorf("failed to replace local file: %v", err)
+	}
+	return nil
+}
+
+// UploadFile copies localPath to remotePath over SFTP, keeping the local file
+// mode. The data is written to a temporary remote file that atomically
+// replaces remotePath after verification.
+func (c *SSHClient) UploadFile(localPath, remotePath string) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file: %v", err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat local file: %v", err)
+	}
+
+	client, err := c.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start sftp session: %v", err)
+	}
+	defer sftpClient.Close()
+
+	tmpPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".devctl.tmp")
+	remoteFile, err := sftpClient.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file: %v", err)
+	}
+
+	if err := remoteFile.Chmod(info.Mode().Perm()); err != nil {
+		remoteFile.Close()
+		sftpClient.Remove(tmpPath)
+		return fmt.Errorf("failed to set remote file permissions: %v", err)
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(remoteFile, io.TeeReader(localFile, hash))
+	if closeErr := remoteFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		sftpClient.Remove(tmpPath)
+		return fmt.Errorf("failed to write remote file: %v", err)
+	}
+
+	remoteInfo, err := sftpClient.Stat(tmpPath)
+	if err != nil || remoteInfo.Size() != info.Size() || written != info.Size() {
+		sftpClient.Remove(tmpPath)
+		return fmt.Errorf("incomplete transfer of %s", localPath)
+	}
+	if err := verifyChecksum(client, tmpPath, hex.EncodeToString(hash.Sum(nil))); err != nil {
+		sftpClient.Remove(tmpPath)
+		return err
+	}
+
+	if err := sftpClient.PosixRename(tmpPath, remotePath); err != nil {
+		sftpClient.Remove(tmpPath)
+		return fmt.Errorf("failed to replace remote file: %v", err)
+	}
+	return nil
+}
+
+// verifyChecksum compares sum with the remote sha256sum of remotePath. Hosts
+// without a usable shell or sha256sum are trusted on the size check alone.
+func verifyChecksum(client *ssh.Client, remotePath, sum string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("sha256sum -- " + shellQuote(remotePath)); err != nil {
+		return nil
+	}
+
+	fields := strings.Fields(stdout.String())
+	if len(fields) == 0 {
+		return nil
+	}
+	if fields[0] != sum {
+		return fmt.Errorf("checksum mismatch for %s: local %s, remote %s", remotePath, sum, fields[0])
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -1,88 +1,264 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"os"
+	"net"
+	"strconv"
+	"time"
 
+	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/credential"
 	"golang.org/x/crypto/ssh"
 )
 
+// DefaultConnectTimeout bounds the TCP dial and SSH handshake when an
+// environment does not set its own timeout.
+const DefaultConnectTimeout = 10 * time.Second
+
 type SSHClient struct {
-	Host     string
-	User     string
-	Password string
+	Host          string
+	Port          int
+	User          string
+	Password      string
+	KeyFiles      []string
+	KeyPassphrase string
+	// AuthMethods is the order in which auth methods are tried, see
+	// DefaultAuthMethods.
+	AuthMethods []string
+	// Store resolves Password and KeyPassphrase when they are credential
+	// references; nil means they are plaintext.
+	Store credential.CredentialStore
+	// ConnectTimeout bounds dialing and the handshake, KeepAlive enables
+	// keepalive@openssh.com requests when non-zero.
+	ConnectTimeout time.Duration
+	KeepAlive      time.Duration
+	// Jumps are dialed in order before the host, each hop tunneled over the
+	// previous one like OpenSSH's ProxyJump.
+	Jumps []*SSHClient
+	// Via, when set, is an established connection the host is dialed
+	// through instead of connecting Jumps, so that clients can share one.
+	Via *ssh.Client
+	// AcceptHostKey, when set, is asked about host keys missing from
+	// known_hosts; the keys it accepts are recorded with TrustHostKey.
+	AcceptHostKey func(*UnknownHostKeyError) bool
+
+	fingerprint string
 }
 
-func NewSSHClient(host, user, password string) *SSHClient {
+func NewSSHClient(host, user, password string, store credential.CredentialStore) *SSHClient {
 	return &SSHClient{
 		Host:     host,
 		User:     user,
 		Password: password,
+		Store:    store,
+	}
+}
+
+func NewSSHClientForEnv(env config.Environment, store credential.CredentialStore) *SSHClient {
+	client := NewSSHClient(env.IP, env.User, env.Password, store)
+	client.KeyFiles = env.KeyFiles
+	client.KeyPassphrase = env.KeyPassphrase
+	client.AuthMethods = env.AuthMethods
+	client.Port = env.Port
+	client.ConnectTimeout = env.ConnectTimeout
+	client.KeepAlive = env.KeepAlive
+	for _, jump := range env.JumpHosts {
+		hop := newSSHClientForAuth(jump.Host, jump.Port, jump.SSHAuth, store)
+		hop.ConnectTimeout = env.ConnectTimeout
+		hop.KeepAlive = env.KeepAlive
+		client.Jumps = append(client.Jumps, hop)
 	}
+	return client
+}
+
+// NewSSHClientForNode returns a client for a node of clusterID, tunneled
+// through the environment's jump host since nodes usually sit on private
+// subnets.
+func NewSSHClientForNode(env config.Environment, clusterID, nodeIP string, store credential.CredentialStore) *SSHClient {
+	bastion := NewSSHClientForEnv(env, store)
+	client := newSSHClientForNode(env, clusterID, nodeIP, store)
+	client.Jumps = append(bastion.Jumps, bastion)
+	bastion.Jumps = nil
+	return client
+}
+
+// NewSSHClientForNodeVia is like NewSSHClientForNode but tunnels through via,
+// an established connection to the environment's jump host, so that many
+// nodes share a single bastion login.
+func NewSSHClientForNodeVia(env config.Environment, clusterID, nodeIP string, via *ssh.Client, store credential.CredentialStore) *SSHClient {
+	client := newSSHClientForNode(env, clusterID, nodeIP, store)
+	client.Via = via
+	return client
+}
+
+func newSSHClientForNode(env config.Environment, clusterID, nodeIP string, store credential.CredentialStore) *SSHClient {
+	login := env.NodeLogin(clusterID)
+	client := newSSHClientForAuth(nodeIP, login.Port, login.SSHAuth, store)
+	client.ConnectTimeout = env.ConnectTimeout
+	client.KeepAlive = env.KeepAlive
+	return client
+}
+
+func newSSHClientForAuth(host string, port int, auth config.SSHAuth, store credential.CredentialStore) *SSHClient {
+	client := NewSSHClient(host, auth.User, auth.Password, store)
+	client.Port = port
+	client.KeyFiles = auth.KeyFiles
+	client.KeyPassphrase = auth.KeyPassphrase
+	client.AuthMethods = auth.AuthMethods
+	return client
+}
+
+// Address returns the host:port the client dials.
+func (c *SSHClient) Address() string {
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+func (c *SSHClient) timeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+	return DefaultConnectTimeout
 }
 
 func (c *SSHClient) Connect() (*ssh.Client, error) {
-	config := &ssh.ClientConfig{
-		User: c.User,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(c.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if c.Via != nil {
+		return c.dialVia(c.Via)
 	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", c.Host), config)
+	hops, err := c.connectJumps()
 	if err != nil {
-		return nil, fmt.Errorf("failed to dial: %v", err)
+		return nil, err
 	}
 
+	client, err := c.dialVia(lastHop(hops))
+	if err != nil {
+		closeHops(hops)
+		return nil, err
+	}
+	if len(hops) > 0 {
+		go func() {
+			client.Wait()
+			closeHops(hops)
+		}()
+	}
 	return client, nil
 }
 
-func (c *SSHClient) DownloadFile(remotePath, localPath string) error {
-	client, err := c.Connect()
-	if err != nil {
-		return err
+// connectJumps connects every jump host in order and returns the clients,
+// the last of which reaches the target.
+func (c *SSHClient) connectJumps() ([]*ssh.Client, error) {
+	var hops []*ssh.Client
+	for i, jump := range c.Jumps {
+		hop, err := jump.dialVia(lastHop(hops))
+		if err != nil {
+			closeHops(hops)
+			return nil, fmt.Errorf("jump host %d (%s): %w", i+1, jump.Address(), err)
+		}
+		hops = append(hops, hop)
 	}
-	defer client.Close()
+	return hops, nil
+}
 
-	session, err := client.NewSession()
+// dialVia connects to the client's address directly, or through via when it
+// is not nil.
+func (c *SSHClient) dialVia(via *ssh.Client) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if via == nil {
+		conn, err = net.DialTimeout("tcp", c.Address(), c.timeout())
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		conn, err = via.DialContext(ctx, "tcp", c.Address())
+		cancel()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create session: %v", err)
+		return nil, fmt.Errorf("failed to dial: %w", err)
 	}
-	defer session.Close()
+	return c.handshake(conn)
+}
 
-	// Create a new file for writing
-	localFile, err := os.Create(localPath)
+func lastHop(hops []*ssh.Client) *ssh.Client {
+	if len(hops) == 0 {
+		return nil
+	}
+	return hops[len(hops)-1]
+}
+
+func closeHops(hops []*ssh.Client) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hops[i].Close()
+	}
+}
+
+// handshake runs the SSH handshake over an established connection, which is
+// closed on failure.
+func (c *SSHClient) handshake(conn net.Conn) (*ssh.Client, error) {
+	auth, cleanup, err := c.authMethods()
 	if err != nil {
-		return fmt.Errorf("failed to create local file: %v", err)
+		conn.Close()
+		return nil, err
 	}
-	defer localFile.Close()
+	defer cleanup()
 
-	// Set up the remote command
-	remoteCmd := fmt.Sprintf("cat %s", remotePath)
-	stdout, err := session.StdoutPipe()
+	hostKeyCallback, algorithms, err := c.hostKeyCallback(conn.RemoteAddr())
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+		conn.Close()
+		return nil, err
 	}
 
-	// Start the remote command
-	if err := session.Start(remoteCmd); err != nil {
-		return fmt.Errorf("failed to start remote command: %v", err)
+	config := &ssh.ClientConfig{
+		User:              c.User,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
 	}
 
-	// Copy the output from the remote command to the local file
-	_, err = io.Copy(localFile, stdout)
+	// Tunneled connections do not support deadlines, so bound the handshake
+	// by closing the connection instead
+	timer := time.AfterFunc(c.timeout(), func() { conn.Close() })
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, c.Address(), config)
+	if !timer.Stop() && err == nil {
+		err = fmt.Errorf("handshake timed out")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to write to local file: %v", err)
+		conn.Close()
+		return nil, fmt.Errorf("failed to dial: %w", err)
 	}
 
-	// Wait for the remote command to finish
-	if err := session.Wait(); err != nil {
-		return fmt.Errorf("failed to wait for remote command: %v", err)
+	client := ssh.NewClient(sshConn, chans, reqs)
+	if c.KeepAlive > 0 {
+		go keepAlive(client, c.KeepAlive)
 	}
+	return client, nil
+}
 
-	return nil
+// keepAlive pings the server until the connection closes, closing it when
+// the server stops answering.
+func keepAlive(client *ssh.Client, interval time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				client.Close()
+				return
+			}
+		}
+	}
 }
 
 func (c *SSHClient) TestConnection() error {
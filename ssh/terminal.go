@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Shell connects and runs an interactive login shell attached to the local
+// terminal, returning the remote exit status.
+func (c *SSHClient) Shell() (int, error) {
+	client, err := c.Connect()
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+
+	return Shell(client)
+}
+
+// Shell runs an interactive login shell over client. The local terminal is
+// put in raw mode for the duration of the session and window size changes are
+// forwarded to the remote PTY.
+func Shell(client *ssh.Client) (int, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return -1, fmt.Errorf("failed to set terminal raw mode: %v", err)
+		}
+		defer term.Restore(fd, state)
+
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		termType := os.Getenv("TERM")
+		if termType == "" {
+			termType = "xterm-256color"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(termType, height, width, modes); err != nil {
+			return -1, fmt.Errorf("failed to request pty: %v", err)
+		}
+
+		stop := watchWindowSize(fd, session)
+		defer stop()
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return -1, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	// The input must be closable so no reader is left behind stealing
+	// keystrokes from the UI once the session ends
+	input := openTerminalInput()
+	defer input.Close()
+	go func() {
+		io.Copy(stdin, input)
+		stdin.Close()
+	}()
+
+	if err := session.Shell(); err != nil {
+		return -1, fmt.Errorf("failed to start shell: %v", err)
+	}
+
+	return exitStatus(session.Wait())
+}
+
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	var missingErr *ssh.ExitMissingError
+	if errors.As(err, &missingErr) {
+		return -1, fmt.Errorf("remote session ended without exit status")
+	}
+	return -1, err
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package ssh
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchWindowSize forwards SIGWINCH to the remote PTY until stop is called.
+func watchWindowSize(fd int, session *ssh.Session) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGWINCH)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigChan:
+				if width, height, err := term.GetSize(fd); err == nil {
+					session.WindowChange(height, width)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
+
+// openTerminalInput opens the controlling terminal through the runtime poller
+// so that closing it unblocks a pending read, unlike os.Stdin.
+func openTerminalInput() io.ReadCloser {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return io.NopCloser(os.Stdin)
+	}
+	return tty
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package ssh
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// watchWindowSize polls the console size, Windows has no SIGWINCH.
+func watchWindowSize(fd int, session *ssh.Session) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		lastWidth, lastHeight, _ := term.GetSize(fd)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				width, height, err := term.GetSize(fd)
+				if err == nil && (width != lastWidth || height != lastHeight) {
+					lastWidth, lastHeight = width, height
+					session.WindowChange(height, width)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+func openTerminalInput() io.ReadCloser {
+	return io.NopCloser(os.Stdin)
+}
@@ -0,0 +1,193 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Tunnel forwards connections accepted on a local port to a remote address
+// over an SSH connection, like ssh -L.
+type Tunnel struct {
+	listener   net.Listener
+	remoteAddr string
+	dial       func(network, addr string) (net.Conn, error)
+}
+
+// Forward starts a tunnel to remoteAddr over client. Closing the tunnel does
+// not close client.
+func Forward(client *ssh.Client, remoteAddr string) (*Tunnel, error) {
+	return newTunnel(client.Dial, remoteAddr)
+}
+
+// Forwarder shares one connection to a host between tunnels, reconnecting on
+// demand after the connection drops. Tunnels keep listening on the same port
+// across reconnects, so the addresses handed out stay valid.
+type Forwarder struct {
+	Client *SSHClient
+
+	mu      sync.Mutex
+	conn    *ssh.Client
+	tunnels map[string]*Tunnel
+}
+
+func NewForwarder(client *SSHClient) *Forwarder {
+	return &Forwarder{Client: client}
+}
+
+// Forward returns the tunnel to remoteAddr, starting it if needed.
+func (f *Forwarder) Forward(remoteAddr string) (*Tunnel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t, ok := f.tunnels[remoteAddr]; ok {
+		return t, nil
+	}
+
+	// Connected up front so that login errors surface here rather than as
+	// refused connections to the tunnel
+	if _, err := f.connectLocked(); err != nil {
+		return nil, err
+	}
+
+	t, err := newTunnel(f.dial, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	if f.tunnels == nil {
+		f.tunnels = make(map[string]*Tunnel)
+	}
+	f.tunnels[remoteAddr] = t
+	return t, nil
+}
+
+// dial opens a connection to addr through the shared connection,
+// reconnecting when it dropped. A drop may not have been noticed yet, so a
+// failure other than the server refusing the channel is retried once on a
+// new connection.
+func (f *Forwarder) dial(network, addr string) (net.Conn, error) {
+	for retry := true; ; retry = false {
+		f.mu.Lock()
+		conn, err := f.connectLocked()
+		f.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		remote, err := conn.Dial(network, addr)
+		var openErr *ssh.OpenChannelError
+		if err == nil || !retry || errors.As(err, &openErr) {
+			return remote, err
+		}
+
+		f.mu.Lock()
+		if f.conn == conn {
+			conn.Close()
+			f.conn = nil
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *Forwarder) connectLocked() (*ssh.Client, error) {
+	if f.conn != nil {
+		return f.conn, nil
+	}
+	conn, err := f.Client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	f.conn = conn
+	go f.watch(conn)
+	return conn, nil
+}
+
+// watch forgets conn once it closes so the next dial reconnects.
+func (f *Forwarder) watch(conn *ssh.Client) {
+	conn.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn == conn {
+		f.conn = nil
+	}
+}
+
+// Close stops every tunnel and the shared connection.
+func (f *Forwarder) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, t := range f.tunnels {
+		t.Close()
+	}
+	f.tunnels = nil
+	if f.conn == nil {
+		return nil
+	}
+	err := f.conn.Close()
+	f.conn = nil
+	return err
+}
+
+func newTunnel(dial func(network, addr string) (net.Conn, error), remoteAddr string) (*Tunnel, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for tunnel: %v", err)
+	}
+
+	t := &Tunnel{
+		listener:   listener,
+		remoteAddr: remoteAddr,
+		dial:       dial,
+	}
+	go t.serve()
+	return t, nil
+}
+
+// LocalAddr is the 127.0.0.1:port address to connect to instead of the
+// remote address.
+func (t *Tunnel) LocalAddr() string {
+	return t.listener.Addr().String()
+}
+
+func (t *Tunnel) Port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (t *Tunnel) Close() error {
+	return t.listener.Close()
+}
+
+func (t *Tunnel) serve() {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer local.Close()
+			remote, err := t.dial("tcp", t.remoteAddr)
+			if err != nil {
+				return
+			}
+			defer remote.Close()
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(remote, local)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(local, remote)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
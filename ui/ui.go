@@ -2,17 +2,21 @@ package ui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/jd/devctl/cluster"
 	"github.com/jd/devctl/config"
+	"github.com/jd/devctl/credential"
 	"github.com/jd/devctl/env"
 	"github.com/jd/devctl/logger"
 	"github.com/jd/devctl/ssh"
@@ -27,6 +31,8 @@ type UI struct {
 	currentEnv     string
 	currentEnvID   string
 	log            *logger.Logger
+	// problems are reported once the UI starts
+	problems []config.Problem
 }
 
 func NewUI(cfg *config.Config, log *logger.Logger) *UI {
@@ -38,8 +44,21 @@ func NewUI(cfg *config.Config, log *logger.Logger) *UI {
 	}
 }
 
+// SetProblems sets the config problems to report on startup.
+func (ui *UI) SetProblems(problems []config.Problem) {
+	ui.problems = problems
+}
+
 func (ui *UI) Run() error {
 	ui.setupPages()
+	if len(ui.problems) > 0 {
+		ui.showProblemsPage(ui.problems)
+	}
+	defer func() {
+		if ui.clusterManager != nil {
+			ui.clusterManager.Close()
+		}
+	}()
 	return ui.app.SetRoot(ui.pages, true).EnableMouse(true).Run()
 }
 
@@ -54,14 +73,14 @@ func (ui *UI) createEnvListPage() tview.Primitive {
 	table := tview.NewTable().
 		SetBorders(false).
 		SetSeparator(tview.Borders.Vertical)
-	header := []string{"Name", "ID", "IP", "User", "Created", "Updated"}
+	header := []string{"Name", "ID", "IP", "User", "Layer", "Created", "Updated"}
 	for i, title := range header {
 		table.SetCell(0, i, tview.NewTableCell(title).SetTextColor(tcell.ColorYellow).SetExpansion(1.0))
 	}
 
 	refreshTable := func() {
 		for i, env := range envs {
-			cells := []string{env.Name, env.ID, env.IP, env.User, env.CreateTime, env.UpdateTime}
+			cells := []string{env.Name, env.ID, env.IP, env.User, env.Layer, config.FormatTime(env.CreateTime), config.FormatTime(env.UpdateTime)}
 			for j, cell := range cells {
 				tableCell := tview.NewTableCell(cell)
 				if i+1 == selectedRow {
@@ -82,10 +101,7 @@ func (ui *UI) createEnvListPage() tview.Primitive {
 		}
 	}).SetSelectedFunc(func(row, column int) {
 		if row > 0 && row <= len(envs) {
-			ui.currentEnv = envs[row-1].Name
-			ui.currentEnvID = envs[row-1].ID
-			ui.clusterManager = cluster.NewClusterManager(ui.currentEnvID, ui.envManager.Config, ui.log)
-			ui.showClusterListPage()
+			ui.openEnvironment(envs[row-1])
 		}
 	})
 
@@ -113,6 +129,20 @@ func (ui *UI) createEnvListPage() tview.Primitive {
 						ui.sshToEnvironment(envs[selectedRow-1])
 					}
 				}
+			case 'u':
+				if selectedRow > 0 && selectedRow <= len(envs) {
+					if envs[selectedRow-1].ID != "default" {
+						ui.showUploadFileForm(envs[selectedRow-1])
+					}
+				}
+			case 'b':
+				ui.showBackupPage()
+			case 'v':
+				if problems := ui.envManager.Config.Validate(); len(problems) > 0 {
+					ui.showProblemsPage(problems)
+				} else {
+					ui.showSuccessModal("No problems found in the config")
+				}
 			}
 		case tcell.KeyUp:
 			if selectedRow > 1 {
@@ -128,10 +158,7 @@ func (ui *UI) createEnvListPage() tview.Primitive {
 			}
 		case tcell.KeyEnter:
 			if selectedRow > 0 && selectedRow <= len(envs) {
-				ui.currentEnv = envs[selectedRow-1].Name
-				ui.currentEnvID = envs[selectedRow-1].ID
-				ui.clusterManager = cluster.NewClusterManager(ui.currentEnvID, ui.envManager.Config, ui.log)
-				ui.showClusterListPage()
+				ui.openEnvironment(envs[selectedRow-1])
 			}
 		}
 		return event
@@ -152,6 +179,22 @@ func (ui *UI) createEnvListPage() tview.Primitive {
 	return flex
 }
 
+// openEnvironment shows the clusters of env, closing the tunnels of the
+// previously opened environment.
+func (ui *UI) openEnvironment(env config.Environment) {
+	if err := ui.envManager.EnsureKubeconfig(env); err != nil {
+		ui.handleError(err, "Failed to download kubeconfig")
+		return
+	}
+	if ui.clusterManager != nil {
+		ui.clusterManager.Close()
+	}
+	ui.currentEnv = env.Name
+	ui.currentEnvID = env.ID
+	ui.clusterManager = cluster.NewClusterManager(ui.currentEnvID, ui.envManager.Config, ui.log)
+	ui.showClusterListPage()
+}
+
 func (ui *UI) createInfoBar() tview.Primitive {
 	info := fmt.Sprintf("DevCtl: v1.0.0\nCPU: %d%%\nMEM: %d%%", 7, 38) // Replace with actual CPU and MEM usage
 	help := strings.Builder{}
@@ -160,6 +203,9 @@ func (ui *UI) createInfoBar() tview.Primitive {
 	help.WriteString("d: 删除环境\n")
 	help.WriteString("m: 修改环境\n")
 	help.WriteString("s: 登录跳板机\n")
+	help.WriteString("u: 上传文件\n")
+	help.WriteString("b: 配置备份与恢复\n")
+	help.WriteString("v: 校验配置\n")
 	help.WriteString("Enter: 进入集群列表\n")
 	help.WriteString("Esc: 退出\n")
 	banner := ui.loadBanner()
@@ -181,7 +227,19 @@ func (ui *UI) createClusterInfoBar() tview.Primitive {
 	help.WriteString("q: 查询\n")
 	help.WriteString("r: 刷新缓存\n")
 	help.WriteString("s: ssh登录节点\n")
-	help.WriteString("Enter: 进入k9s界面\n")
+	help.WriteString("l: 设置节点登录凭据\n")
+	help.WriteString("u: 进入集群shell(KUBECONFIG)\n")
+	help.WriteString("Space/v: 选择集群或节点/全选\n")
+	help.WriteString("x: 在所选集群或节点执行命令\n")
+	for _, launcher := range ui.envManager.Config.GetLaunchers() {
+		key := launcher.Key
+		if strings.EqualFold(key, "enter") {
+			key = "Enter"
+		}
+		if key != "" {
+			help.WriteString(fmt.Sprintf("%s: 启动%s\n", key, launcher.Name))
+		}
+	}
 	help.WriteString("Esc: 退出\n")
 
 	banner := ui.loadBanner()
@@ -240,6 +298,131 @@ func (ui *UI) deleteSelectedEnvironment(table *tview.Table) {
 	ui.pages.AddPage("deleteConfirm", modal, true, true)
 }
 
+// showProblemsPage reports problems found in the config, most of which are
+// fixed by updating, deleting or re-adding an environment.
+func (ui *UI) showProblemsPage(problems []config.Problem) {
+	text := strings.Builder{}
+	for _, problem := range problems {
+		text.WriteString("- " + problem.String() + "\n")
+	}
+	text.WriteString("\nFix the environments with 'm' or 'd', or edit ~/.devctl/config.yaml and restart devctl.")
+
+	view := tview.NewTextView().SetScrollable(true).SetWrap(true).SetText(text.String())
+	view.SetBorder(true).SetTitle(fmt.Sprintf("配置问题 (%d) - Esc: 关闭", len(problems)))
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Key() == tcell.KeyEnter {
+			ui.pages.RemovePage("problems")
+			return nil
+		}
+		return event
+	})
+
+	ui.pages.AddPage("problems", ui.modal(view, 90, 20), true, true)
+}
+
+// showBackupPage lists the config backups with what restoring the selected
+// one would change.
+func (ui *UI) showBackupPage() {
+	backups, err := config.ListBackups()
+	if err != nil {
+		ui.handleError(err, "Failed to list backups")
+		return
+	}
+
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for i, header := range []string{"Time", "Environments"} {
+		table.SetCell(0, i, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetSelectable(false).SetExpansion(1))
+	}
+	for i, backup := range backups {
+		envs := strings.Join(backup.EnvIDs, ", ")
+		if backup.Err != nil {
+			envs = backup.Err.Error()
+		}
+		table.SetCell(i+1, 0, tview.NewTableCell(backup.Time.Format(config.TimeLayout)))
+		table.SetCell(i+1, 1, tview.NewTableCell(envs))
+	}
+
+	diffView := tview.NewTextView().SetScrollable(true)
+	diffView.SetBorder(true).SetTitle("Restore Changes")
+	showDiff := func(row int) {
+		diffView.Clear()
+		if row < 1 || row > len(backups) {
+			return
+		}
+		restored, err := backups[row-1].Load()
+		if err != nil {
+			diffView.SetText(err.Error())
+			return
+		}
+		diffs := config.DiffConfigs(ui.envManager.Config, ui.envManager.Config.Compose(restored))
+		if len(diffs) == 0 {
+			diffView.SetText("Same environments as the current config")
+			return
+		}
+		for _, diff := range diffs {
+			fmt.Fprint(diffView, diff)
+		}
+		diffView.ScrollToBeginning()
+	}
+	table.SetSelectionChangedFunc(func(row, column int) {
+		showDiff(row)
+	})
+	table.Select(1, 0)
+	showDiff(1)
+
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			ui.pages.RemovePage("backups")
+			return nil
+		case event.Key() == tcell.KeyRune && event.Rune() == 'r':
+			if row, _ := table.GetSelection(); row > 0 && row <= len(backups) {
+				ui.confirmRestoreBackup(backups[row-1])
+			}
+			return nil
+		}
+		return event
+	})
+
+	title := fmt.Sprintf("配置备份 (%d) - r: 恢复, Esc: 返回", len(backups))
+	frame := tview.NewFrame(table).
+		SetBorders(0, 0, 0, 0, 0, 0).
+		AddText(title, true, tview.AlignCenter, tcell.ColorWhite)
+
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(frame, 0, 1, true).
+		AddItem(diffView, 0, 1, false)
+
+	ui.pages.AddPage("backups", flex, true, true)
+}
+
+func (ui *UI) confirmRestoreBackup(backup config.Backup) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Restore the config from %s?\nThe current config is backed up first.", backup.Time.Format(config.TimeLayout))).
+		AddButtons([]string{"Yes", "No"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage("restoreConfirm")
+			if buttonLabel != "Yes" {
+				return
+			}
+			missing, err := ui.envManager.RestoreBackup(backup)
+			if err != nil {
+				ui.handleError(err, "Failed to restore backup")
+				return
+			}
+			ui.pages.RemovePage("backups")
+			ui.setupPages() // Refresh the environment list
+			if len(missing) > 0 {
+				ui.showErrorModal(fmt.Sprintf("Config restored, but the kubeconfig of %s could not be downloaded", strings.Join(missing, ", ")))
+				return
+			}
+			ui.showSuccessModal("Config restored successfully")
+		})
+
+	ui.pages.AddPage("restoreConfirm", modal, true, true)
+}
+
 func (ui *UI) showUpdateEnvironmentForm(table *tview.Table) {
 	row, _ := table.GetSelection()
 	if row == 0 {
@@ -258,8 +441,22 @@ func (ui *UI) showUpdateEnvironmentForm(table *tview.Table) {
 	form.AddInputField("IP", env.IP, 20, nil, func(text string) {
 		env.IP = text
 	})
-	form.AddPasswordField("Password", env.Password, 20, '*', func(text string) {
-		env.Password = text
+	form.AddInputField("Port", portText(env.Port), 6, tview.InputFieldInteger, func(text string) {
+		env.Port, _ = strconv.Atoi(text)
+	})
+	addSecretField(form, "Password", &env.Password)
+	form.AddInputField("Key Files", strings.Join(env.KeyFiles, ","), 30, nil, func(text string) {
+		env.KeyFiles = splitList(text)
+	})
+	addSecretField(form, "Key Passphrase", &env.KeyPassphrase)
+	form.AddInputField("Auth Order", strings.Join(env.AuthMethods, ","), 40, nil, func(text string) {
+		env.AuthMethods = splitList(text)
+	})
+	form.AddCheckbox("Tunnel API Server", env.TunnelAPIServer, func(checked bool) {
+		env.TunnelAPIServer = checked
+	})
+	form.AddInputField("Proxy", env.Proxy, 40, nil, func(text string) {
+		env.Proxy = strings.TrimSpace(text)
 	})
 
 	form.AddButton("Save", func() {
@@ -275,7 +472,34 @@ func (ui *UI) showUpdateEnvironmentForm(table *tview.Table) {
 		ui.pages.RemovePage("updateEnv")
 	})
 
-	ui.pages.AddPage("updateEnv", ui.modal(form, 60, 10), true, true)
+	ui.pages.AddPage("updateEnv", ui.modal(form, 70, 22), true, true)
+}
+
+func (ui *UI) showUploadFileForm(env config.Environment) {
+	form := tview.NewForm()
+	localPath := env.Kubeconfig
+	remotePath := "/root/.kube/config"
+
+	form.AddInputField("Local Path", localPath, 40, nil, func(text string) {
+		localPath = text
+	})
+	form.AddInputField("Remote Path", remotePath, 40, nil, func(text string) {
+		remotePath = text
+	})
+
+	form.AddButton("Upload", func() {
+		if err := ui.envManager.UploadFile(env.ID, localPath, remotePath); err != nil {
+			ui.handleError(err, "Failed to upload file")
+		} else {
+			ui.pages.RemovePage("uploadFile")
+			ui.showSuccessModal(fmt.Sprintf("Uploaded %s to %s", localPath, remotePath))
+		}
+	})
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("uploadFile")
+	})
+
+	ui.pages.AddPage("uploadFile", ui.modal(form, 70, 9), true, true)
 }
 
 func (ui *UI) showAddEnvironmentForm() {
@@ -291,24 +515,38 @@ func (ui *UI) showAddEnvironmentForm() {
 	form.AddInputField("IP", "", 20, nil, func(text string) {
 		env.IP = text
 	})
+	form.AddInputField("Port", "22", 6, tview.InputFieldInteger, func(text string) {
+		env.Port, _ = strconv.Atoi(text)
+	})
 	form.AddInputField("User", "", 20, nil, func(text string) {
 		env.User = text
 	})
-	form.AddPasswordField("Password", "", 20, '*', func(text string) {
-		env.Password = text
+	addSecretField(form, "Password", &env.Password)
+	form.AddInputField("Key Files", "", 30, nil, func(text string) {
+		env.KeyFiles = splitList(text)
+	})
+	addSecretField(form, "Key Passphrase", &env.KeyPassphrase)
+	form.AddInputField("Auth Order", strings.Join(ssh.DefaultAuthMethods, ","), 40, nil, func(text string) {
+		env.AuthMethods = splitList(text)
+	})
+	form.AddCheckbox("Tunnel API Server", false, func(checked bool) {
+		env.TunnelAPIServer = checked
+	})
+	form.AddInputField("Proxy", "", 40, nil, func(text string) {
+		env.Proxy = strings.TrimSpace(text)
 	})
 
 	form.AddButton("Test Connection", func() {
-		sshClient := ssh.NewSSHClient(env.IP, env.User, env.Password)
+		sshClient := ssh.NewSSHClientForEnv(env, nil)
 		if err := sshClient.TestConnection(); err != nil {
 			ui.handleError(err, "Connection test failed")
 		} else {
-			ui.showSuccessModal("Connection test successful")
+			ui.showSuccessModal(fmt.Sprintf("Connection test successful\nHost key: %s", sshClient.Fingerprint()))
 		}
 	})
 
 	form.AddButton("Save", func() {
-		sshClient := ssh.NewSSHClient(env.IP, env.User, env.Password)
+		sshClient := ssh.NewSSHClientForEnv(env, nil)
 		if err := sshClient.TestConnection(); err != nil {
 			ui.handleError(err, "Connection test failed")
 			return
@@ -326,14 +564,48 @@ func (ui *UI) showAddEnvironmentForm() {
 		ui.pages.RemovePage("addEnv")
 	})
 
-	ui.pages.AddPage("addEnv", ui.modal(form, 60, 20), true, true)
+	ui.pages.AddPage("addEnv", ui.modal(form, 70, 32), true, true)
 }
 
 func (ui *UI) handleError(err error, context string) {
 	ui.log.Error("Error in %s: %v", context, err)
+
+	var unknownKey *ssh.UnknownHostKeyError
+	if errors.As(err, &unknownKey) {
+		ui.showTrustHostKeyModal(unknownKey)
+		return
+	}
+	var changedKey *ssh.HostKeyChangedError
+	if errors.As(err, &changedKey) {
+		ui.showErrorModal(fmt.Sprintf("%s: %v\n\nIf the host was reinstalled, remove the old entry from %s and try again.",
+			context, changedKey, changedKey.Known.Filename))
+		return
+	}
+
 	ui.showErrorModal(fmt.Sprintf("%s: %v", context, err))
 }
 
+func (ui *UI) showTrustHostKeyModal(keyErr *ssh.UnknownHostKeyError) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("The authenticity of host %s can't be established.\n%s key fingerprint is\n%s\n\nTrust this host?",
+			keyErr.Host, keyErr.Key.Type(), keyErr.Fingerprint())).
+		AddButtons([]string{"Trust", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage("trustHostKey")
+			if buttonLabel != "Trust" {
+				return
+			}
+			if err := ssh.TrustHostKey(keyErr.Host, keyErr.Key); err != nil {
+				ui.showErrorModal(fmt.Sprintf("Failed to save host key: %v", err))
+				return
+			}
+			ui.log.Info("Trusted host key %s for %s", keyErr.Fingerprint(), keyErr.Host)
+			ui.showSuccessModal(fmt.Sprintf("Host key for %s saved, please retry", keyErr.Host))
+		})
+
+	ui.pages.AddPage("trustHostKey", modal, true, true)
+}
+
 func (ui *UI) showSuccessModal(message string) {
 	ui.log.Info("Success: %s", message)
 	modal := tview.NewModal().
@@ -347,7 +619,7 @@ func (ui *UI) showSuccessModal(message string) {
 }
 
 func (ui *UI) showErrorModal(message string) {
-	ui.log.Error(message)
+	ui.log.Error("%s", message)
 	modal := tview.NewModal().
 		SetText(message).
 		AddButtons([]string{"OK"}).
@@ -359,7 +631,7 @@ func (ui *UI) showErrorModal(message string) {
 }
 
 func (ui *UI) showInfoModal(message string) {
-	ui.log.Info(message)
+	ui.log.Info("%s", message)
 	modal := tview.NewModal().
 		SetText(message).
 		AddButtons([]string{"OK"}).
@@ -396,6 +668,8 @@ func (ui *UI) showClusterListPage() {
 		table.SetCell(0, i, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetExpansion(1.0))
 	}
 	selectedRow := 1
+	// Clusters marked for running a command across them, by ID
+	marked := make(map[string]bool)
 
 	var filteredClusters []cluster.ClusterInfo
 	filterClusters := func(query string) {
@@ -420,9 +694,14 @@ func (ui *UI) showClusterListPage() {
 			cells := []string{cluster.ID, cluster.Name, cluster.OS, cluster.ARCH, cluster.Version, cluster.Cri, cluster.Status}
 			for j, cell := range cells {
 				tableCell := tview.NewTableCell(cell)
-				if i+1 == selectedRow {
+				switch {
+				case i+1 == selectedRow && marked[cluster.ID]:
+					tableCell.SetTextColor(tcell.ColorBlack).SetBackgroundColor(tcell.ColorYellow)
+				case i+1 == selectedRow:
 					tableCell.SetTextColor(tcell.ColorBlack).SetBackgroundColor(tcell.ColorWhite)
-				} else {
+				case marked[cluster.ID]:
+					tableCell.SetTextColor(tcell.ColorYellow).SetBackgroundColor(tcell.ColorBlack)
+				default:
 					tableCell.SetTextColor(tcell.ColorWhite).SetBackgroundColor(tcell.ColorBlack)
 				}
 				table.SetCell(i+1, j, tableCell)
@@ -501,6 +780,76 @@ func (ui *UI) showClusterListPage() {
 					ui.log.Info("DEBUG: Preparing to show node list for cluster ID: %s, Name: %s", clusterInfo.ID, clusterInfo.Name)
 					ui.showNodeListPage(clusterInfo)
 				}
+			case 'l':
+				row, _ := table.GetSelection()
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				if row > 0 && row <= len(clustersToShow) {
+					ui.showNodeLoginForm(clustersToShow[row-1])
+				}
+			case 'u':
+				row, _ := table.GetSelection()
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				if row > 0 && row <= len(clustersToShow) {
+					ui.openShell(clustersToShow[row-1].ID)
+				}
+			case ' ':
+				row, _ := table.GetSelection()
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				if row > 0 && row <= len(clustersToShow) {
+					id := clustersToShow[row-1].ID
+					marked[id] = !marked[id]
+					refreshTable()
+				}
+				return nil
+			case 'v':
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				all := true
+				for _, c := range clustersToShow {
+					all = all && marked[c.ID]
+				}
+				for _, c := range clustersToShow {
+					marked[c.ID] = !all
+				}
+				refreshTable()
+			case 'x':
+				var targets []cluster.Target
+				for _, c := range clusters {
+					if marked[c.ID] {
+						targets = append(targets, cluster.Target{EnvID: ui.currentEnvID, ClusterID: c.ID})
+					}
+				}
+				row, _ := table.GetSelection()
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				if len(targets) == 0 && row > 0 && row <= len(clustersToShow) {
+					targets = append(targets, cluster.Target{EnvID: ui.currentEnvID, ClusterID: clustersToShow[row-1].ID})
+				}
+				if len(targets) > 0 {
+					ui.showFanOutForm(targets)
+				}
+			default:
+				row, _ := table.GetSelection()
+				clustersToShow := clusters
+				if len(filteredClusters) > 0 {
+					clustersToShow = filteredClusters
+				}
+				if launcher, ok := ui.launcherFor(string(event.Rune())); ok && row > 0 && row <= len(clustersToShow) {
+					ui.launch(launcher, clustersToShow[row-1])
+				}
 			}
 		case tcell.KeyUp:
 			if selectedRow > 1 {
@@ -523,9 +872,8 @@ func (ui *UI) showClusterListPage() {
 			if len(filteredClusters) > 0 {
 				clustersToShow = filteredClusters
 			}
-			if selectedRow > 0 && selectedRow <= len(clustersToShow) {
-				clusterInfo := clustersToShow[selectedRow-1]
-				ui.openK9s(clusterInfo.ID)
+			if launcher, ok := ui.launcherFor("enter"); ok && selectedRow > 0 && selectedRow <= len(clustersToShow) {
+				ui.launch(launcher, clustersToShow[selectedRow-1])
 			}
 		}
 		return event
@@ -564,6 +912,8 @@ func (ui *UI) showNodeListPage(clusterInfo cluster.ClusterInfo) {
 		table.SetCell(0, i, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetExpansion(1.0))
 	}
 	selectedRow := 1
+	// Nodes marked for running a command across them, by IP
+	marked := make(map[string]bool)
 
 	refreshTable := func() {
 		table.Clear()
@@ -574,9 +924,14 @@ func (ui *UI) showNodeListPage(clusterInfo cluster.ClusterInfo) {
 			cells := []string{node.Name, node.IP}
 			for j, cell := range cells {
 				tableCell := tview.NewTableCell(cell)
-				if i+1 == selectedRow {
+				switch {
+				case i+1 == selectedRow && marked[node.IP]:
+					tableCell.SetTextColor(tcell.ColorBlack).SetBackgroundColor(tcell.ColorYellow)
+				case i+1 == selectedRow:
 					tableCell.SetTextColor(tcell.ColorBlack).SetBackgroundColor(tcell.ColorWhite)
-				} else {
+				case marked[node.IP]:
+					tableCell.SetTextColor(tcell.ColorYellow).SetBackgroundColor(tcell.ColorBlack)
+				default:
 					tableCell.SetTextColor(tcell.ColorWhite).SetBackgroundColor(tcell.ColorBlack)
 				}
 				table.SetCell(i+1, j, tableCell)
@@ -610,7 +965,41 @@ func (ui *UI) showNodeListPage(clusterInfo cluster.ClusterInfo) {
 		case tcell.KeyEnter:
 			if selectedRow > 0 && selectedRow <= len(nodes) {
 				nodeInfo := nodes[selectedRow-1]
-				ui.sshToNode(nodeInfo.IP)
+				ui.sshToNode(clusterInfo.ID, nodeInfo.IP)
+			}
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case 'l':
+				ui.showNodeLoginForm(clusterInfo)
+			case ' ':
+				if selectedRow > 0 && selectedRow <= len(nodes) {
+					ip := nodes[selectedRow-1].IP
+					marked[ip] = !marked[ip]
+					refreshTable()
+				}
+				return nil
+			case 'v':
+				all := true
+				for _, node := range nodes {
+					all = all && marked[node.IP]
+				}
+				for _, node := range nodes {
+					marked[node.IP] = !all
+				}
+				refreshTable()
+			case 'x':
+				var targets []cluster.NodeInfo
+				for _, node := range nodes {
+					if marked[node.IP] {
+						targets = append(targets, node)
+					}
+				}
+				if len(targets) == 0 && selectedRow > 0 && selectedRow <= len(nodes) {
+					targets = append(targets, nodes[selectedRow-1])
+				}
+				if len(targets) > 0 {
+					ui.showNodeExecForm(clusterInfo, targets)
+				}
 			}
 		}
 		return event
@@ -631,65 +1020,415 @@ func (ui *UI) showNodeListPage(clusterInfo cluster.ClusterInfo) {
 	ui.pages.AddPage("nodeList", flex, true, true)
 }
 
-func (ui *UI) sshToNode(nodeIP string) {
+// showNodeExecForm asks for a command to run over SSH on nodes.
+func (ui *UI) showNodeExecForm(clusterInfo cluster.ClusterInfo, nodes []cluster.NodeInfo) {
+	form := tview.NewForm()
+	command := ""
+	timeout := 60
+
+	form.AddInputField("Command", "systemctl is-active kubelet", 50, nil, func(text string) {
+		command = text
+	})
+	form.AddInputField("Timeout (s)", strconv.Itoa(timeout), 6, tview.InputFieldInteger, func(text string) {
+		timeout, _ = strconv.Atoi(text)
+	})
+	form.AddButton("Run", func() {
+		if strings.TrimSpace(command) == "" {
+			command = "systemctl is-active kubelet"
+		}
+		ui.pages.RemovePage("nodeExec")
+		ui.showNodeExecResults(clusterInfo, nodes, command, time.Duration(timeout)*time.Second)
+	})
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("nodeExec")
+	})
+	form.SetTitle(fmt.Sprintf("Run on %d node(s)", len(nodes))).SetBorder(true)
+
+	ui.pages.AddPage("nodeExec", ui.modal(form, 70, 9), true, true)
+}
+
+// showNodeExecResults runs command on nodes in the background, streaming
+// their output prefixed with the node name below a table of exit statuses.
+// Leaving the page cancels the commands still running.
+func (ui *UI) showNodeExecResults(clusterInfo cluster.ClusterInfo, nodes []cluster.NodeInfo, command string, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for i, header := range []string{"Node Name", "Internal IP", "Exit", "Duration", "Status"} {
+		table.SetCell(0, i, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetSelectable(false).SetExpansion(1))
+	}
+	rows := make(map[string]int)
+	for i, node := range nodes {
+		rows[node.IP] = i + 1
+		table.SetCell(i+1, 0, tview.NewTableCell(node.Name))
+		table.SetCell(i+1, 1, tview.NewTableCell(node.IP))
+		table.SetCell(i+1, 2, tview.NewTableCell("-"))
+		table.SetCell(i+1, 3, tview.NewTableCell("-"))
+		table.SetCell(i+1, 4, tview.NewTableCell("running"))
+	}
+
+	output := tview.NewTextView().SetScrollable(true)
+	output.SetBorder(true).SetTitle("Output")
+
+	frame := tview.NewFrame(table).
+		SetBorders(0, 0, 0, 0, 0, 0).
+		AddText(fmt.Sprintf("执行结果 - %s: %s (%d)", clusterInfo.Name, command, len(nodes)), true, tview.AlignCenter, tcell.ColorWhite)
+
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(frame, 0, 1, true).
+		AddItem(output, 0, 2, false)
+
+	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			cancel()
+			ui.pages.RemovePage("nodeExecResults")
+			ui.pages.SwitchToPage("nodeList")
+			return nil
+		case tcell.KeyTab:
+			if table.HasFocus() {
+				ui.app.SetFocus(output)
+			} else {
+				ui.app.SetFocus(table)
+			}
+			return nil
+		}
+		return event
+	})
+
+	ui.pages.AddPage("nodeExecResults", flex, true, true)
+
+	cm := ui.clusterManager
+	go func() {
+		defer cancel()
+		results, err := cm.RunOnNodes(ctx, clusterInfo.ID, nodes, command, cluster.DefaultNodeExecWorkers, timeout, nil, func(node cluster.NodeInfo, line string) {
+			ui.app.QueueUpdateDraw(func() {
+				fmt.Fprintf(output, "[%s] %s\n", node.Name, line)
+			})
+		}, func(result cluster.NodeResult) {
+			ui.app.QueueUpdateDraw(func() {
+				row := rows[result.Node.IP]
+				status, color := "ok", tcell.ColorGreen
+				if result.Err != nil {
+					status, color = result.Err.Error(), tcell.ColorRed
+				} else if result.ExitCode != 0 {
+					status, color = "failed", tcell.ColorRed
+				}
+				table.SetCell(row, 2, tview.NewTableCell(strconv.Itoa(result.ExitCode)).SetTextColor(color))
+				table.SetCell(row, 3, tview.NewTableCell(result.Duration.Round(time.Millisecond).String()))
+				table.SetCell(row, 4, tview.NewTableCell(status).SetTextColor(color))
+			})
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			ui.app.QueueUpdateDraw(func() {
+				ui.handleError(err, "Failed to run command on nodes")
+			})
+			return
+		}
+
+		var unknown []cluster.NodeResult
+		for _, result := range results {
+			var keyErr *ssh.UnknownHostKeyError
+			if errors.As(result.Err, &keyErr) {
+				unknown = append(unknown, result)
+			}
+		}
+		if len(unknown) > 0 {
+			ui.app.QueueUpdateDraw(func() {
+				ui.showTrustNodeHostKeysModal(clusterInfo, unknown, command, timeout)
+			})
+		}
+	}()
+}
+
+// showTrustNodeHostKeysModal lists the fingerprints of the nodes that failed
+// on an unknown host key and offers to trust them all and run command again
+// on those nodes.
+func (ui *UI) showTrustNodeHostKeysModal(clusterInfo cluster.ClusterInfo, results []cluster.NodeResult, command string, timeout time.Duration) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "The authenticity of %d node(s) can't be established:\n\n", len(results))
+	for _, result := range results {
+		var keyErr *ssh.UnknownHostKeyError
+		errors.As(result.Err, &keyErr)
+		fmt.Fprintf(&text, "%s (%s)\n%s %s\n", result.Node.Name, result.Node.IP, keyErr.Key.Type(), keyErr.Fingerprint())
+	}
+	text.WriteString("\nTrust these hosts and run the command on them again?")
+
+	modal := tview.NewModal().
+		SetText(text.String()).
+		AddButtons([]string{"Trust all", "Cancel"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			ui.pages.RemovePage("trustNodeHostKeys")
+			if buttonLabel != "Trust all" {
+				return
+			}
+			var nodes []cluster.NodeInfo
+			for _, result := range results {
+				var keyErr *ssh.UnknownHostKeyError
+				errors.As(result.Err, &keyErr)
+				if err := ssh.TrustHostKey(keyErr.Host, keyErr.Key); err != nil {
+					ui.showErrorModal(fmt.Sprintf("Failed to save host key: %v", err))
+					return
+				}
+				ui.log.Info("Trusted host key %s for node %s", keyErr.Fingerprint(), keyErr.Host)
+				nodes = append(nodes, result.Node)
+			}
+			ui.pages.RemovePage("nodeExecResults")
+			ui.showNodeExecResults(clusterInfo, nodes, command, timeout)
+		})
+
+	ui.pages.AddPage("trustNodeHostKeys", modal, true, true)
+}
+
+func (ui *UI) sshToNode(clusterID, nodeIP string) {
 	env, err := ui.envManager.GetEnvironment(ui.currentEnvID)
 	if err != nil {
 		ui.handleError(err, "Failed to get environment for SSH")
 		return
 	}
 
-	ui.log.Info("Connecting to node: %s with user %s", nodeIP, env.User)
+	ui.log.Info("Connecting to node: %s with user %s via %s", nodeIP, env.NodeLogin(clusterID).User, env.IP)
+
+	store, err := ui.envManager.Config.CredentialStore()
+	if err != nil {
+		ui.handleError(err, "Failed to open credential store")
+		return
+	}
+
+	ui.runShell(ssh.NewSSHClientForNode(env, clusterID, nodeIP, store))
+}
 
-	cmd := exec.Command("sshpass", "-p", env.Password, "ssh", "-o", "StrictHostKeyChecking=no", fmt.Sprintf("%s@%s", env.User, nodeIP))
+// showNodeLoginForm edits the credentials used for the nodes of a cluster,
+// which default to the jump host's.
+func (ui *UI) showNodeLoginForm(clusterInfo cluster.ClusterInfo) {
+	env, err := ui.envManager.GetEnvironment(ui.currentEnvID)
+	if err != nil {
+		ui.handleError(err, "Failed to get environment")
+		return
+	}
+
+	login := env.NodeLogin(clusterInfo.ID)
+	login.Cluster = clusterInfo.ID
+
+	form := tview.NewForm()
+	form.AddInputField("User", login.User, 20, nil, func(text string) {
+		login.User = text
+	})
+	form.AddInputField("Port", portText(login.Port), 6, tview.InputFieldInteger, func(text string) {
+		login.Port, _ = strconv.Atoi(text)
+	})
+	addSecretField(form, "Password", &login.Password)
+	form.AddInputField("Key Files", strings.Join(login.KeyFiles, ","), 30, nil, func(text string) {
+		login.KeyFiles = splitList(text)
+	})
+	addSecretField(form, "Key Passphrase", &login.KeyPassphrase)
+	form.AddInputField("Auth Order", strings.Join(login.AuthMethods, ","), 40, nil, func(text string) {
+		login.AuthMethods = splitList(text)
+	})
+
+	form.AddButton("Save", func() {
+		env.SetNodeLogin(login)
+		if err := ui.envManager.UpdateEnvironment(env); err != nil {
+			ui.handleError(err, "Failed to update node login")
+		} else {
+			ui.pages.RemovePage("nodeLogin")
+			ui.showSuccessModal(fmt.Sprintf("Node login for '%s' saved", clusterInfo.Name))
+		}
+	})
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("nodeLogin")
+	})
+
+	ui.pages.AddPage("nodeLogin", ui.modal(form, 70, 20), true, true)
+}
+
+// showFanOutForm asks for a shell command to run against targets.
+func (ui *UI) showFanOutForm(targets []cluster.Target) {
+	form := tview.NewForm()
+	command := ""
+	timeout := 60
+
+	form.AddInputField("Command", "kubectl get nodes", 50, nil, func(text string) {
+		command = text
+	})
+	form.AddInputField("Timeout (s)", strconv.Itoa(timeout), 6, tview.InputFieldInteger, func(text string) {
+		timeout, _ = strconv.Atoi(text)
+	})
+	form.AddButton("Run", func() {
+		if strings.TrimSpace(command) == "" {
+			command = "kubectl get nodes"
+		}
+		ui.pages.RemovePage("fanOut")
+		ui.showFanOutResults(targets, command, time.Duration(timeout)*time.Second)
+	})
+	form.AddButton("Cancel", func() {
+		ui.pages.RemovePage("fanOut")
+	})
+	form.SetTitle(fmt.Sprintf("Run on %d cluster(s)", len(targets))).SetBorder(true)
+
+	ui.pages.AddPage("fanOut", ui.modal(form, 70, 9), true, true)
+}
+
+// showFanOutResults runs command against targets in the background, listing
+// the exit status of each cluster as it finishes along with the output of
+// the selected one. Leaving the page cancels the commands still running.
+func (ui *UI) showFanOutResults(targets []cluster.Target, command string, timeout time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	results := make([]*cluster.ExecResult, len(targets))
+	rows := make(map[cluster.Target]int)
+
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for i, header := range []string{"Context", "Exit", "Duration", "Status"} {
+		table.SetCell(0, i, tview.NewTableCell(header).SetTextColor(tcell.ColorYellow).SetSelectable(false).SetExpansion(1))
+	}
+	for i, target := range targets {
+		rows[target] = i + 1
+		table.SetCell(i+1, 0, tview.NewTableCell(target.String()))
+		table.SetCell(i+1, 1, tview.NewTableCell("-"))
+		table.SetCell(i+1, 2, tview.NewTableCell("-"))
+		table.SetCell(i+1, 3, tview.NewTableCell("running"))
+	}
+
+	output := tview.NewTextView().SetScrollable(true)
+	output.SetBorder(true).SetTitle("Output")
+	showOutput := func(row int) {
+		output.Clear()
+		if row > 0 && row <= len(results) && results[row-1] != nil {
+			output.SetText(string(results[row-1].Output)).ScrollToBeginning()
+		}
+	}
+	table.SetSelectionChangedFunc(func(row, column int) {
+		showOutput(row)
+	})
+	table.Select(1, 0)
+
+	frame := tview.NewFrame(table).
+		SetBorders(0, 0, 0, 0, 0, 0).
+		AddText(fmt.Sprintf("执行结果 - %s (%d)", command, len(targets)), true, tview.AlignCenter, tcell.ColorWhite)
+
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(frame, 0, 1, true).
+		AddItem(output, 0, 2, false)
+
+	flex.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			cancel()
+			ui.pages.RemovePage("fanOutResults")
+			ui.pages.SwitchToPage("clusterList")
+			return nil
+		case tcell.KeyTab:
+			if table.HasFocus() {
+				ui.app.SetFocus(output)
+			} else {
+				ui.app.SetFocus(table)
+			}
+			return nil
+		}
+		return event
+	})
+
+	ui.pages.AddPage("fanOutResults", flex, true, true)
+
+	go cluster.FanOut(ctx, ui.envManager.Config, ui.log, targets, cluster.ShellArgs(command), cluster.DefaultFanOutWorkers, timeout, func(result cluster.ExecResult) {
+		ui.app.QueueUpdateDraw(func() {
+			row := rows[result.Target]
+			results[row-1] = &result
+
+			status, color := "ok", tcell.ColorGreen
+			if result.Err != nil {
+				status, color = result.Err.Error(), tcell.ColorRed
+			} else if result.ExitCode != 0 {
+				status, color = "failed", tcell.ColorRed
+			}
+			table.SetCell(row, 1, tview.NewTableCell(strconv.Itoa(result.ExitCode)).SetTextColor(color))
+			table.SetCell(row, 2, tview.NewTableCell(result.Duration.Round(time.Millisecond).String()))
+			table.SetCell(row, 3, tview.NewTableCell(status).SetTextColor(color))
+
+			if selected, _ := table.GetSelection(); selected == row {
+				showOutput(row)
+			}
+		})
+	})
+}
+
+// launch runs launcher against the selected cluster with the UI suspended.
+func (ui *UI) launch(launcher config.Launcher, clusterInfo cluster.ClusterInfo) {
+	cmd, err := ui.clusterManager.LaunchCommand(launcher, clusterInfo)
+	if err != nil {
+		ui.handleError(err, fmt.Sprintf("Error preparing %s", launcher.Name))
+		return
+	}
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+
+	// Ctrl-C belongs to the launched command, not to devctl
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
 	ui.app.Suspend(func() {
-		err := cmd.Run()
-		if err != nil {
-			ui.log.Error("Failed to connect to node: %v", err)
+		if err := cmd.Run(); err != nil {
+			ui.handleError(err, fmt.Sprintf("%s command failed", launcher.Name))
 		}
 	})
 }
 
-func (ui *UI) openK9s(clusterName string) {
-	var kubeconfigPath string
-	var err error
-	if clusterName == "gaia" {
-		kubeconfigPath = filepath.Join(os.Getenv("HOME"), ".devctl", "kubeconfigs", ui.currentEnvID, "config")
-	} else {
-		kubeconfigPath, err = ui.clusterManager.GetKubeconfig(clusterName)
-		if err != nil {
-			ui.handleError(err, "Error getting kubeconfig")
-			return
+// launcherFor returns the launcher bound to key, "enter" or a character.
+func (ui *UI) launcherFor(key string) (config.Launcher, bool) {
+	for _, launcher := range ui.envManager.Config.GetLaunchers() {
+		if launcher.BoundTo(key) {
+			return launcher, true
 		}
 	}
+	return config.Launcher{}, false
+}
 
-	if _, err := os.Stat(kubeconfigPath); os.IsNotExist(err) {
-		ui.handleError(err, fmt.Sprintf("Kubeconfig file not found for cluster %s", clusterName))
+// openShell starts the user's shell with KUBECONFIG set to the cluster, keeping
+// any API server tunnel open until the shell exits.
+func (ui *UI) openShell(clusterID string) {
+	kubeconfigPath, err := ui.clusterManager.GetKubeconfig(clusterID)
+	if err != nil {
+		ui.handleError(err, "Error getting kubeconfig")
+		return
+	}
+	kubeconfigPath, err = ui.clusterManager.LaunchKubeconfig(kubeconfigPath)
+	if err != nil {
+		ui.handleError(err, "Error tunneling to API server")
 		return
 	}
-	ctx := context.Background()
-	childCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sigChan
-		fmt.Println("Received interrupt signal, canceling context")
-		cancel()
-	}()
 
-	cmd := exec.CommandContext(childCtx, "k9s", "--kubeconfig", kubeconfigPath, "--logLevel", "debug")
-	cmd.Env = append(os.Environ(), "EDITOR=vim")
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+		if runtime.GOOS == "windows" {
+			shell = "cmd.exe"
+		}
+	}
+
+	contextName := cluster.ContextName(ui.currentEnvID, clusterID)
+	cmd := exec.Command(shell)
+	cmd.Env = append(ui.clusterManager.LaunchEnv(), "KUBECONFIG="+kubeconfigPath, cluster.ContextEnv+"="+contextName)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
+	// Ctrl-C belongs to the shell, not to devctl
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
 	ui.app.Suspend(func() {
-		err := cmd.Run()
-		if err != nil {
-			ui.handleError(err, "k9s command failed")
+		fmt.Printf("devctl: using %s, exit the shell to return\n", contextName)
+		if err := cmd.Run(); err != nil {
+			ui.log.Error("Shell for %s exited: %v", contextName, err)
 		}
 	})
 }
@@ -781,15 +1520,61 @@ func (ui *UI) showAddClusterForm() {
 func (ui *UI) sshToEnvironment(env config.Environment) {
 	ui.log.Info("Connecting to environment: %s", env.Name)
 
-	cmd := exec.Command("sshpass", "-p", env.Password, "ssh", "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", fmt.Sprintf("%s@%s", env.User, env.IP))
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	store, err := ui.envManager.Config.CredentialStore()
+	if err != nil {
+		ui.handleError(err, "Failed to open credential store")
+		return
+	}
+
+	ui.runShell(ssh.NewSSHClientForEnv(env, store))
+}
+
+// runShell connects before suspending the UI, so that auth and host key
+// problems are reported in the UI, then hands the terminal to a remote shell.
+func (ui *UI) runShell(client *ssh.SSHClient) {
+	conn, err := client.Connect()
+	if err != nil {
+		ui.handleError(err, fmt.Sprintf("Failed to connect to %s", client.Address()))
+		return
+	}
+	defer conn.Close()
 
 	ui.app.Suspend(func() {
-		err := cmd.Run()
+		status, err := ssh.Shell(conn)
 		if err != nil {
-			ui.log.Error("Failed to connect to environment: %v", err)
+			ui.handleError(err, fmt.Sprintf("SSH session to %s failed", client.Address()))
+			return
 		}
+		ui.log.Info("SSH session to %s exited with status %d", client.Address(), status)
 	})
 }
+
+// addSecretField adds an empty password field to form. The secret in value,
+// often a credential reference, is kept unless a new one is typed.
+func addSecretField(form *tview.Form, label string, value *string) {
+	stored := *value
+	form.AddPasswordField(label, "", 20, '*', func(text string) {
+		if text == "" {
+			*value = stored
+		} else {
+			*value = credential.Escape(text)
+		}
+	})
+}
+
+func portText(port int) string {
+	if port == 0 {
+		return "22"
+	}
+	return strconv.Itoa(port)
+}
+
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}